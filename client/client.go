@@ -2,23 +2,42 @@ package client
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
+	"sync"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
 )
 
-// Client defines typed wrappers for the Ethereum RPC API.
+// ErrNotFound is returned by TransactionReceipt and TransactionByHash when the
+// node does not know the requested transaction.
+var ErrNotFound = errors.New("not found")
+
+// Client defines typed wrappers for the Ethereum RPC API, sent to the first
+// healthy of its endpoints.
 type Client struct {
-	c *rpc.Client
+	lock      *sync.RWMutex
+	endpoints []*endpoint
+	opts      Options
+
+	quit chan struct{}
+	once sync.Once
 }
 
 // NewClient creates a client that uses the given RPC client.
 func NewClient(c *rpc.Client) *Client {
-	return &Client{c}
+	return &Client{
+		lock:      &sync.RWMutex{},
+		endpoints: []*endpoint{{c: c, healthy: true}},
+		quit:      make(chan struct{}),
+	}
 }
 
 // DialContext connects a client to the given URL.
@@ -27,25 +46,36 @@ func DialContext(ctx context.Context, rawurl string) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewClient(c), nil
+	ec := NewClient(c)
+	ec.endpoints[0].URL = rawurl
+	return ec, nil
 }
 
 // Close close connect
 func (ec *Client) Close() {
-	ec.c.Close()
+	ec.once.Do(func() {
+		close(ec.quit)
+		ec.lock.RLock()
+		defer ec.lock.RUnlock()
+		for _, e := range ec.endpoints {
+			if e.c != nil {
+				e.c.Close()
+			}
+		}
+	})
 }
 
 // BlockNumberAt get the number of most recent block.
 func (ec *Client) BlockNumberAt(ctx context.Context) (*big.Int, error) {
 	var result hexutil.Big
-	err := ec.c.CallContext(ctx, &result, "platon_blockNumber")
+	err := ec.call(ctx, &result, "platon_blockNumber")
 	return (*big.Int)(&result), err
 }
 
 // GasPrice the current price per gas in von.
 func (ec *Client) GasPrice(ctx context.Context) (*big.Int, error) {
 	var result hexutil.Big
-	err := ec.c.CallContext(ctx, &result, "platon_gasPrice")
+	err := ec.call(ctx, &result, "platon_gasPrice")
 	return (*big.Int)(&result), err
 }
 
@@ -53,7 +83,7 @@ func (ec *Client) GasPrice(ctx context.Context) (*big.Int, error) {
 // The block number can be nil, in which case the balance is taken from the latest known block.
 func (ec *Client) BalanceAt(ctx context.Context, account string, blockNumber *big.Int) (*big.Int, error) {
 	var result hexutil.Big
-	err := ec.c.CallContext(ctx, &result, "platon_getBalance", account, toBlockNumArg(blockNumber))
+	err := ec.call(ctx, &result, "platon_getBalance", account, toBlockNumArg(blockNumber))
 	return (*big.Int)(&result), err
 }
 
@@ -61,7 +91,7 @@ func (ec *Client) BalanceAt(ctx context.Context, account string, blockNumber *bi
 func (ec *Client) NetworkID(ctx context.Context) (string, *big.Int, error) {
 	version := new(big.Int)
 	var ver string
-	if err := ec.c.CallContext(ctx, &ver, "net_version"); err != nil {
+	if err := ec.call(ctx, &ver, "net_version"); err != nil {
 		return ver, nil, err
 	}
 	if _, ok := version.SetString(ver, 10); !ok {
@@ -78,21 +108,144 @@ func (ec *Client) NetworkID(ctx context.Context) (string, *big.Int, error) {
 // blocks might not be available.
 func (ec *Client) CallContract(ctx context.Context, msg CallMsg, blockNumber *big.Int) ([]byte, error) {
 	var hex hexutil.Bytes
-	err := ec.c.CallContext(ctx, &hex, "platon_call", toCallArg(msg), toBlockNumArg(blockNumber))
+	err := ec.call(ctx, &hex, "platon_call", toCallArg(msg), toBlockNumArg(blockNumber))
 	if err != nil {
 		return nil, err
 	}
 	return hex, nil
 }
 
+// EstimateGas tries to estimate the gas needed to execute a specific transaction based on
+// the current pending state of the backend blockchain.
+func (ec *Client) EstimateGas(ctx context.Context, msg CallMsg) (uint64, error) {
+	var hex hexutil.Uint64
+	err := ec.call(ctx, &hex, "platon_estimateGas", toCallArg(msg))
+	if err != nil {
+		return 0, err
+	}
+	return uint64(hex), nil
+}
+
 // NonceAt returns the account nonce of the given account.
 // The block number can be nil, in which case the nonce is taken from the latest known block.
 func (ec *Client) NonceAt(ctx context.Context, account string, blockNumber *big.Int) (uint64, error) {
 	var result hexutil.Uint64
-	err := ec.c.CallContext(ctx, &result, "platon_getTransactionCount", account, toBlockNumArg(blockNumber))
+	err := ec.call(ctx, &result, "platon_getTransactionCount", account, toBlockNumArg(blockNumber))
+	return uint64(result), err
+}
+
+// PendingNonceAt returns the account nonce of the given account in the pending state.
+// This is the nonce that should be used for the next transaction.
+func (ec *Client) PendingNonceAt(ctx context.Context, account string) (uint64, error) {
+	var result hexutil.Uint64
+	err := ec.call(ctx, &result, "platon_getTransactionCount", account, "pending")
 	return uint64(result), err
 }
 
+// batchSize is the most requests sent in one batch, nodes cap the size of a
+// request body.
+const batchSize = 300
+
+// AccountState is an account as read by BatchAccounts.
+type AccountState struct {
+	Account string
+	Balance *big.Int
+	Nonce   uint64 // pending
+	Call    []byte // the result of the call of the account, nil without one
+	Err     error  // the first request of the account that failed
+}
+
+// BatchAccounts reads the balance and pending nonce of every account, the
+// result of calls[i] for accounts[i] when calls is not nil, and the gas
+// price, in batches of batchSize requests. A failed request fails its account
+// only, a failed batch or gas price fails the whole read.
+func (ec *Client) BatchAccounts(ctx context.Context, accounts []string, calls []CallMsg) ([]*AccountState, *big.Int, error) {
+	var gasPrice hexutil.Big
+	elems := []rpc.BatchElem{{Method: "platon_gasPrice", Result: &gasPrice}}
+	type result struct {
+		balance hexutil.Big
+		nonce   hexutil.Uint64
+		call    hexutil.Bytes
+		elems   []int
+	}
+	results := make([]*result, len(accounts))
+	for i, account := range accounts {
+		r := &result{}
+		r.elems = append(r.elems, len(elems), len(elems)+1)
+		elems = append(elems,
+			rpc.BatchElem{Method: "platon_getBalance", Args: []interface{}{account, "latest"}, Result: &r.balance},
+			rpc.BatchElem{Method: "platon_getTransactionCount", Args: []interface{}{account, "pending"}, Result: &r.nonce})
+		if calls != nil {
+			r.elems = append(r.elems, len(elems))
+			elems = append(elems, rpc.BatchElem{Method: "platon_call", Args: []interface{}{toCallArg(calls[i]), "latest"}, Result: &r.call})
+		}
+		results[i] = r
+	}
+
+	for start := 0; start < len(elems); start += batchSize {
+		end := start + batchSize
+		if end > len(elems) {
+			end = len(elems)
+		}
+		if err := ec.batch(ctx, elems[start:end]); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := elems[0].Error; err != nil {
+		return nil, nil, err
+	}
+
+	states := make([]*AccountState, len(accounts))
+	for i, r := range results {
+		st := &AccountState{Account: accounts[i], Balance: (*big.Int)(&r.balance), Nonce: uint64(r.nonce)}
+		if calls != nil {
+			st.Call = r.call
+		}
+		for _, e := range r.elems {
+			if err := elems[e].Error; err != nil {
+				st.Err = fmt.Errorf("%s: %w", elems[e].Method, err)
+				break
+			}
+		}
+		states[i] = st
+	}
+	return states, (*big.Int)(&gasPrice), nil
+}
+
+// RecentGasPrices returns the gas prices of the transactions in the last
+// blocks blocks, read in one batch request.
+func (ec *Client) RecentGasPrices(ctx context.Context, blocks int) ([]*big.Int, error) {
+	head, err := ec.BlockNumberAt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	type block struct {
+		Transactions []*rpcTransaction `json:"transactions"`
+	}
+	var elems []rpc.BatchElem
+	var results []*block
+	for n := head.Int64(); n >= 0 && len(elems) < blocks; n-- {
+		b := &block{}
+		results = append(results, b)
+		elems = append(elems, rpc.BatchElem{Method: "platon_getBlockByNumber", Args: []interface{}{hexutil.EncodeBig(big.NewInt(n)), true}, Result: b})
+	}
+	if err := ec.batch(ctx, elems); err != nil {
+		return nil, err
+	}
+	var prices []*big.Int
+	for i, b := range results {
+		if err := elems[i].Error; err != nil {
+			return nil, fmt.Errorf("%s: %w", elems[i].Method, err)
+		}
+		for _, tx := range b.Transactions {
+			if tx.GasPrice != nil {
+				prices = append(prices, tx.GasPrice.ToInt())
+			}
+		}
+	}
+	return prices, nil
+}
+
 // SendTransaction injects a signed transaction into the pending pool for execution.
 //
 // If the transaction was a contract creation use the TransactionReceipt method to get the
@@ -102,7 +255,7 @@ func (ec *Client) SendTransaction(ctx context.Context, tx *types.Transaction) er
 	if err != nil {
 		return err
 	}
-	return ec.c.CallContext(ctx, nil, "platon_sendRawTransaction", hexutil.Encode(data))
+	return ec.call(ctx, nil, "platon_sendRawTransaction", hexutil.Encode(data))
 }
 
 // SendPendingTransaction injects a signed transaction into the pending pool for execution.
@@ -114,7 +267,124 @@ func (ec *Client) SendPendingTransaction(ctx context.Context, tx *types.Transact
 	if err != nil {
 		return err
 	}
-	return ec.c.CallContext(ctx, nil, "platon_sendRawTransaction", hexutil.Encode(data), "pending")
+	return ec.call(ctx, nil, "platon_sendRawTransaction", hexutil.Encode(data), "pending")
+}
+
+// TransactionReceipt returns the receipt of a transaction by transaction hash.
+// Note that the receipt is not available for pending transactions.
+func (ec *Client) TransactionReceipt(ctx context.Context, txHash common.Hash) (*Receipt, error) {
+	var r *rpcReceipt
+	err := ec.call(ctx, &r, "platon_getTransactionReceipt", txHash)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, ErrNotFound
+	}
+	return r.toReceipt(), nil
+}
+
+// TransactionByHash returns the transaction with the given hash.
+func (ec *Client) TransactionByHash(ctx context.Context, hash common.Hash) (tx *Transaction, isPending bool, err error) {
+	var r *rpcTransaction
+	err = ec.call(ctx, &r, "platon_getTransactionByHash", hash)
+	if err != nil {
+		return nil, false, err
+	}
+	if r == nil {
+		return nil, false, ErrNotFound
+	}
+	tx = r.toTransaction()
+	return tx, tx.BlockNumber == nil, nil
+}
+
+// EconomicConfig returns the economic model of the chain as json. The node
+// returns it as a json encoded string, which is unwrapped here.
+func (ec *Client) EconomicConfig(ctx context.Context) ([]byte, error) {
+	var raw json.RawMessage
+	err := ec.call(ctx, &raw, "debug_economicConfig")
+	if err != nil {
+		return nil, err
+	}
+	var str string
+	if json.Unmarshal(raw, &str) == nil {
+		return []byte(str), nil
+	}
+	return raw, nil
+}
+
+// WatchHeads sends every new chain head until ctx is done, then closes the
+// channel. It subscribes to newHeads when the endpoint supports it (websocket,
+// ipc) and polls platon_blockNumber every poll otherwise (http). A broken
+// subscription is subscribed again after poll.
+func (ec *Client) WatchHeads(ctx context.Context, poll time.Duration) <-chan *Header {
+	heads := make(chan *Header, 16)
+	go func() {
+		defer close(heads)
+		for ctx.Err() == nil {
+			if !ec.subscribeHeads(ctx, heads) {
+				ec.pollHeads(ctx, heads, poll)
+				return
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(poll):
+			}
+		}
+	}()
+	return heads
+}
+
+// subscribeHeads forwards heads until the subscription breaks, it returns
+// false when the subscription could not be made at all.
+func (ec *Client) subscribeHeads(ctx context.Context, heads chan<- *Header) (subscribed bool) {
+	endpoints := ec.candidates()
+	if len(endpoints) == 0 {
+		return false
+	}
+	ch := make(chan *rpcHeader, 16)
+	sub, err := endpoints[0].c.Subscribe(ctx, "platon", ch, "newHeads")
+	if err != nil {
+		return false
+	}
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case <-sub.Err():
+			return true
+		case h := <-ch:
+			select {
+			case heads <- &Header{Number: (*big.Int)(h.Number), Hash: h.Hash}:
+			case <-ctx.Done():
+				return true
+			}
+		}
+	}
+}
+
+func (ec *Client) pollHeads(ctx context.Context, heads chan<- *Header, poll time.Duration) {
+	t := time.NewTicker(poll)
+	defer t.Stop()
+	last := big.NewInt(-1)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			number, err := ec.BlockNumberAt(ctx)
+			if err != nil || number.Cmp(last) <= 0 {
+				continue
+			}
+			last = number
+			select {
+			case heads <- &Header{Number: number}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
 }
 
 func toCallArg(msg CallMsg) interface{} {
@@ -157,3 +427,101 @@ type CallMsg struct {
 	Value    *big.Int // amount of wei sent along with the call
 	Data     []byte   // input data, usually an ABI-encoded contract method invocation
 }
+
+// Header is the part of a block header the job uses, Hash is empty when the
+// head was polled.
+type Header struct {
+	Number *big.Int
+	Hash   common.Hash
+}
+
+type rpcHeader struct {
+	Number *hexutil.Big `json:"number"`
+	Hash   common.Hash  `json:"hash"`
+}
+
+// Receipt represents the results of a transaction.
+type Receipt struct {
+	TxHash      common.Hash
+	BlockHash   common.Hash
+	BlockNumber uint64
+	Status      uint64 // 1 for success, 0 for failure
+	GasUsed     uint64
+	Logs        []*Log
+}
+
+// Log represents a contract log event, the built-in PPOS contracts report their
+// result code through it.
+type Log struct {
+	Address string // bech32 or hex, depending on the node version
+	Topics  []common.Hash
+	Data    []byte
+}
+
+// Transaction is a transaction as returned by platon_getTransactionByHash.
+type Transaction struct {
+	Hash        common.Hash
+	From        string
+	To          string
+	Nonce       uint64
+	Gas         uint64
+	GasPrice    *big.Int
+	Value       *big.Int
+	Input       []byte
+	BlockNumber *big.Int // nil while the transaction is pending
+}
+
+type rpcLog struct {
+	Address string        `json:"address"`
+	Topics  []common.Hash `json:"topics"`
+	Data    hexutil.Bytes `json:"data"`
+}
+
+type rpcReceipt struct {
+	TxHash      common.Hash    `json:"transactionHash"`
+	BlockHash   common.Hash    `json:"blockHash"`
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	Status      hexutil.Uint64 `json:"status"`
+	GasUsed     hexutil.Uint64 `json:"gasUsed"`
+	Logs        []*rpcLog      `json:"logs"`
+}
+
+func (r *rpcReceipt) toReceipt() *Receipt {
+	receipt := &Receipt{
+		TxHash:      r.TxHash,
+		BlockHash:   r.BlockHash,
+		BlockNumber: uint64(r.BlockNumber),
+		Status:      uint64(r.Status),
+		GasUsed:     uint64(r.GasUsed),
+	}
+	for _, l := range r.Logs {
+		receipt.Logs = append(receipt.Logs, &Log{Address: l.Address, Topics: l.Topics, Data: l.Data})
+	}
+	return receipt
+}
+
+type rpcTransaction struct {
+	Hash        common.Hash    `json:"hash"`
+	From        string         `json:"from"`
+	To          string         `json:"to"`
+	Nonce       hexutil.Uint64 `json:"nonce"`
+	Gas         hexutil.Uint64 `json:"gas"`
+	GasPrice    *hexutil.Big   `json:"gasPrice"`
+	Value       *hexutil.Big   `json:"value"`
+	Input       hexutil.Bytes  `json:"input"`
+	BlockNumber *hexutil.Big   `json:"blockNumber"`
+}
+
+func (r *rpcTransaction) toTransaction() *Transaction {
+	return &Transaction{
+		Hash:        r.Hash,
+		From:        r.From,
+		To:          r.To,
+		Nonce:       uint64(r.Nonce),
+		Gas:         uint64(r.Gas),
+		GasPrice:    (*big.Int)(r.GasPrice),
+		Value:       (*big.Int)(r.Value),
+		Input:       r.Input,
+		BlockNumber: (*big.Int)(r.BlockNumber),
+	}
+}
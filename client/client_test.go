@@ -2,7 +2,13 @@ package client
 
 import (
 	"context"
+	"errors"
+	"math/big"
+	"strconv"
 	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 func TestGasPrice(t *testing.T) {
@@ -16,3 +22,62 @@ func TestGasPrice(t *testing.T) {
 	}
 	t.Log(n.Int64())
 }
+
+// testPlatonAPI answers the state of an account with its index.
+type testPlatonAPI struct{}
+
+func (api *testPlatonAPI) GasPrice() *hexutil.Big {
+	return (*hexutil.Big)(big.NewInt(1e9))
+}
+
+func (api *testPlatonAPI) GetBalance(account, block string) (*hexutil.Big, error) {
+	if account == "bad" {
+		return nil, errors.New("invalid address")
+	}
+	i, _ := strconv.Atoi(account)
+	return (*hexutil.Big)(big.NewInt(int64(i) * 10)), nil
+}
+
+func (api *testPlatonAPI) GetTransactionCount(account, block string) hexutil.Uint64 {
+	i, _ := strconv.Atoi(account)
+	return hexutil.Uint64(i)
+}
+
+func (api *testPlatonAPI) Call(arg map[string]interface{}, block string) hexutil.Bytes {
+	return hexutil.Bytes(arg["from"].(string))
+}
+
+func TestBatchAccounts(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("platon", &testPlatonAPI{}); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	c := NewClient(rpc.DialInProc(server))
+
+	// more requests than one batch holds
+	var accounts []string
+	var calls []CallMsg
+	for i := 0; i < batchSize/2; i++ {
+		accounts = append(accounts, strconv.Itoa(i))
+		calls = append(calls, CallMsg{From: strconv.Itoa(i), To: "contract"})
+	}
+	accounts = append(accounts, "bad")
+	calls = append(calls, CallMsg{From: "bad", To: "contract"})
+
+	states, gasPrice, err := c.BatchAccounts(context.Background(), accounts, calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gasPrice.Int64() != 1e9 {
+		t.Errorf("got gas price %s", gasPrice)
+	}
+	for i, st := range states[:len(states)-1] {
+		if st.Err != nil || st.Balance.Int64() != int64(i)*10 || st.Nonce != uint64(i) || string(st.Call) != strconv.Itoa(i) {
+			t.Errorf("account %d: got balance %s, nonce %d, call %q, err %v", i, st.Balance, st.Nonce, st.Call, st.Err)
+		}
+	}
+	if states[len(states)-1].Err == nil {
+		t.Errorf("bad account: got no error")
+	}
+}
@@ -0,0 +1,284 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"k8s.io/klog"
+)
+
+const (
+	defaultMaxLag = uint64(10)
+	defaultProbe  = 15 * time.Second
+	probeTimeout  = 5 * time.Second
+)
+
+// Endpoint is a node the client sends its requests to. Healthy endpoints are
+// used by Priority, lowest first, and in the given order for equal ones.
+type Endpoint struct {
+	URL      string
+	Priority int
+}
+
+// Options of a client with several endpoints.
+type Options struct {
+	// NetworkID is the net_version every endpoint must report, default the
+	// one of the first endpoint that answers the probe
+	NetworkID string
+	// MaxLag is how many blocks an endpoint may be behind the highest one
+	MaxLag uint64
+	// Probe is how often the health of the endpoints is checked
+	Probe time.Duration
+}
+
+type endpoint struct {
+	Endpoint
+
+	c       *rpc.Client
+	healthy bool
+	reason  string // why it is unhealthy
+	height  uint64
+}
+
+// EndpointStatus is the health of an endpoint as of the last probe or request.
+type EndpointStatus struct {
+	URL      string `json:"url"`
+	Priority int    `json:"priority"`
+	Healthy  bool   `json:"healthy"`
+	Reason   string `json:"reason,omitempty"`
+	Height   uint64 `json:"height"`
+}
+
+// DialEndpoints connects a client to every endpoint and checks their health
+// every opts.Probe until the client is closed. Requests go to the first
+// healthy endpoint and fail over to the next one when it cannot be reached,
+// an error the node answers with is returned as is. It fails when no endpoint
+// is healthy.
+func DialEndpoints(ctx context.Context, endpoints []Endpoint, opts Options) (*Client, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("no endpoint")
+	}
+	if opts.MaxLag == 0 {
+		opts.MaxLag = defaultMaxLag
+	}
+	if opts.Probe <= 0 {
+		opts.Probe = defaultProbe
+	}
+	ec := &Client{lock: &sync.RWMutex{}, opts: opts, quit: make(chan struct{})}
+	for _, e := range endpoints {
+		ec.endpoints = append(ec.endpoints, &endpoint{Endpoint: e})
+	}
+	sort.SliceStable(ec.endpoints, func(i, j int) bool {
+		return ec.endpoints[i].Priority < ec.endpoints[j].Priority
+	})
+
+	ec.probe(ctx)
+	if len(ec.healthy()) == 0 {
+		ec.Close()
+		var reasons []string
+		for _, st := range ec.Endpoints() {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", st.URL, st.Reason))
+		}
+		return nil, fmt.Errorf("no healthy endpoint: %s", strings.Join(reasons, "; "))
+	}
+	go ec.probeLoop()
+	return ec, nil
+}
+
+// Endpoints returns the health of every endpoint, by priority.
+func (ec *Client) Endpoints() []*EndpointStatus {
+	ec.lock.RLock()
+	defer ec.lock.RUnlock()
+	statuses := make([]*EndpointStatus, 0, len(ec.endpoints))
+	for _, e := range ec.endpoints {
+		statuses = append(statuses, &EndpointStatus{URL: e.URL, Priority: e.Priority, Healthy: e.healthy, Reason: e.reason, Height: e.height})
+	}
+	return statuses
+}
+
+func (ec *Client) probeLoop() {
+	t := time.NewTicker(ec.opts.Probe)
+	defer t.Stop()
+	for {
+		select {
+		case <-ec.quit:
+			return
+		case <-t.C:
+			ec.probe(context.Background())
+		}
+	}
+}
+
+// probe reads the net_version and height of every endpoint, dialing again
+// the ones that could not be dialed. An endpoint is unhealthy when it does not
+// answer, is on another network or lags more than MaxLag blocks behind the
+// highest endpoint.
+func (ec *Client) probe(ctx context.Context) {
+	type result struct {
+		c       *rpc.Client
+		version string
+		height  uint64
+		err     error
+	}
+	ec.lock.RLock()
+	endpoints := append([]*endpoint{}, ec.endpoints...)
+	clients := make([]*rpc.Client, len(endpoints))
+	for i, e := range endpoints {
+		clients[i] = e.c
+	}
+	ec.lock.RUnlock()
+
+	results := make([]*result, len(endpoints))
+	var wg sync.WaitGroup
+	for i, e := range endpoints {
+		wg.Add(1)
+		go func(i int, url string, c *rpc.Client) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+			defer cancel()
+			r := &result{c: c}
+			results[i] = r
+			if r.c == nil {
+				if r.c, r.err = rpc.DialContext(ctx, url); r.err != nil {
+					return
+				}
+			}
+			var height hexutil.Uint64
+			elems := []rpc.BatchElem{
+				{Method: "net_version", Result: &r.version},
+				{Method: "platon_blockNumber", Result: &height},
+			}
+			if r.err = r.c.BatchCallContext(ctx, elems); r.err != nil {
+				return
+			}
+			for _, elem := range elems {
+				if elem.Error != nil {
+					r.err = fmt.Errorf("%s: %w", elem.Method, elem.Error)
+					return
+				}
+			}
+			r.height = uint64(height)
+		}(i, e.URL, clients[i])
+	}
+	wg.Wait()
+
+	ec.lock.Lock()
+	defer ec.lock.Unlock()
+	var highest uint64
+	for _, r := range results {
+		if r.err == nil {
+			if ec.opts.NetworkID == "" {
+				ec.opts.NetworkID = r.version
+			}
+			if r.version == ec.opts.NetworkID && r.height > highest {
+				highest = r.height
+			}
+		}
+	}
+	for i, e := range endpoints {
+		r := results[i]
+		if e.c == nil {
+			e.c = r.c
+		}
+		healthy, reason := true, ""
+		switch {
+		case r.err != nil:
+			healthy, reason = false, r.err.Error()
+		case r.version != ec.opts.NetworkID:
+			healthy, reason = false, fmt.Sprintf("net_version %s, want %s", r.version, ec.opts.NetworkID)
+		case r.height+ec.opts.MaxLag < highest:
+			healthy, reason = false, fmt.Sprintf("block %d, %d behind", r.height, highest-r.height)
+		}
+		if r.err == nil {
+			e.height = r.height
+		}
+		if healthy != e.healthy {
+			if healthy {
+				klog.Infof("[Client probe] endpoint %s is healthy, block %d", e.URL, e.height)
+			} else {
+				klog.Warningf("[Client probe] endpoint %s is unhealthy: %s", e.URL, reason)
+			}
+		}
+		e.healthy, e.reason = healthy, reason
+	}
+}
+
+// healthy returns the healthy endpoints by priority.
+func (ec *Client) healthy() []*endpoint {
+	ec.lock.RLock()
+	defer ec.lock.RUnlock()
+	var endpoints []*endpoint
+	for _, e := range ec.endpoints {
+		if e.healthy && e.c != nil {
+			endpoints = append(endpoints, e)
+		}
+	}
+	return endpoints
+}
+
+// candidates returns the endpoints a request is tried on, the healthy ones,
+// else every dialed one as a last resort.
+func (ec *Client) candidates() []*endpoint {
+	if endpoints := ec.healthy(); len(endpoints) > 0 {
+		return endpoints
+	}
+	ec.lock.RLock()
+	defer ec.lock.RUnlock()
+	var endpoints []*endpoint
+	for _, e := range ec.endpoints {
+		if e.c != nil {
+			endpoints = append(endpoints, e)
+		}
+	}
+	return endpoints
+}
+
+// down marks the endpoint unhealthy until the next probe finds it healthy.
+func (ec *Client) down(e *endpoint, err error) {
+	ec.lock.Lock()
+	defer ec.lock.Unlock()
+	if e.healthy {
+		klog.Warningf("[Client] endpoint %s is unhealthy, fail over: %s", e.URL, err)
+	}
+	e.healthy, e.reason = false, err.Error()
+}
+
+// failover reports whether the request should be tried on the next endpoint:
+// the endpoint could not be reached, not answered with an error.
+func failover(ctx context.Context, err error) bool {
+	var rpcErr rpc.Error
+	return err != nil && ctx.Err() == nil && !errors.As(err, &rpcErr)
+}
+
+// call runs the request on the first endpoint that answers.
+func (ec *Client) call(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	return ec.try(ctx, func(c *rpc.Client) error {
+		return c.CallContext(ctx, result, method, args...)
+	})
+}
+
+// batch runs the batch on the first endpoint that answers.
+func (ec *Client) batch(ctx context.Context, elems []rpc.BatchElem) error {
+	return ec.try(ctx, func(c *rpc.Client) error {
+		return c.BatchCallContext(ctx, elems)
+	})
+}
+
+func (ec *Client) try(ctx context.Context, request func(c *rpc.Client) error) error {
+	err := errors.New("no endpoint")
+	for _, e := range ec.candidates() {
+		err = request(e.c)
+		if !failover(ctx, err) {
+			return err
+		}
+		ec.down(e, err)
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// testNode answers the health probe.
+type testNode struct {
+	version string
+	height  uint64
+}
+
+func (n *testNode) Version() string {
+	return n.version
+}
+
+func (n *testNode) BlockNumber() hexutil.Uint64 {
+	return hexutil.Uint64(n.height)
+}
+
+func startNode(t *testing.T, node *testNode) *httptest.Server {
+	server := rpc.NewServer()
+	if err := server.RegisterName("net", node); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterName("platon", node); err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(server)
+}
+
+func TestDialEndpoints(t *testing.T) {
+	ctx := context.Background()
+	primary := startNode(t, &testNode{version: "1", height: 100})
+	lagging := startNode(t, &testNode{version: "1", height: 50})
+	defer lagging.Close()
+	other := startNode(t, &testNode{version: "2", height: 200})
+	defer other.Close()
+	backup := startNode(t, &testNode{version: "1", height: 99})
+	defer backup.Close()
+
+	ec, err := DialEndpoints(ctx, []Endpoint{
+		{URL: backup.URL, Priority: 2},
+		{URL: other.URL, Priority: 1},
+		{URL: lagging.URL, Priority: 1},
+		{URL: primary.URL},
+	}, Options{MaxLag: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ec.Close()
+
+	healthy := map[string]bool{primary.URL: true, lagging.URL: false, other.URL: false, backup.URL: true}
+	for _, st := range ec.Endpoints() {
+		if st.Healthy != healthy[st.URL] {
+			t.Errorf("%s: got healthy %v (%s), want %v", st.URL, st.Healthy, st.Reason, healthy[st.URL])
+		}
+	}
+
+	number, err := ec.BlockNumberAt(ctx)
+	if err != nil || number.Uint64() != 100 {
+		t.Fatalf("got block %v, %v, want 100 from the primary", number, err)
+	}
+	// the primary goes away, requests fail over to the backup
+	primary.Close()
+	number, err = ec.BlockNumberAt(ctx)
+	if err != nil || number.Uint64() != 99 {
+		t.Fatalf("got block %v, %v, want 99 from the backup", number, err)
+	}
+}
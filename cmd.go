@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discv5"
+
+	"gitee.com/zonzpoo/platonjob/client"
+	"gitee.com/zonzpoo/platonjob/conf"
+	"gitee.com/zonzpoo/platonjob/internal"
+	"gitee.com/zonzpoo/platonjob/utils"
+)
+
+// output is the result of a command, printed as a table or as json.
+type output struct {
+	header []string
+	rows   [][]string
+	data   interface{}
+}
+
+func (o *output) print(format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(o.data)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(o.header, "\t"))
+		for _, row := range o.rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+type command func(ctx context.Context, svc internal.SvcImpl) (*output, error)
+
+var commands = map[string]command{
+	"balance":    balanceCmd,
+	"rewards":    rewardsCmd,
+	"withdraw":   withdrawCmd,
+	"delegate":   delegateCmd,
+	"undelegate": undelegateCmd,
+	"status":     statusCmd,
+	"candidates": candidatesCmd,
+	"select":     selectCmd,
+	"migrate":    migrateCmd,
+	"compound":   compoundCmd,
+}
+
+// keyCommands manage the keys and run without connecting to the node or
+// loading the configured addresses.
+var keyCommands = map[string]func(ctx context.Context) (*output, error){
+	"import-key": importKeyCmd,
+	"list-keys":  listKeysCmd,
+	"derive":     deriveCmd,
+}
+
+func commandNames() string {
+	names := make([]string, 0, len(commands)+len(keyCommands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	for name := range keyCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func runCmd(ctx context.Context, cmd string) error {
+	if run, ok := keyCommands[cmd]; ok {
+		out, err := run(ctx)
+		if err != nil {
+			return err
+		}
+		return out.print(outputFormat)
+	}
+	run, ok := commands[cmd]
+	if !ok {
+		return fmt.Errorf("unknown command: %s, available: %s", cmd, commandNames())
+	}
+	svc, err := internal.New(ctx, ac)
+	if err != nil {
+		return err
+	}
+	if addrFilter != "" {
+		svc.Filter(strings.Split(addrFilter, ",")...)
+	}
+	out, err := run(ctx, svc)
+	if err != nil {
+		return err
+	}
+	return out.print(outputFormat)
+}
+
+type balanceRow struct {
+	Name        string `json:"name"`
+	Address     string `json:"address"`
+	Balance     string `json:"balance"`
+	Restricting string `json:"restricting"`
+}
+
+func balanceCmd(ctx context.Context, svc internal.SvcImpl) (*output, error) {
+	out := &output{header: []string{"NAME", "ADDRESS", "BALANCE", "RESTRICTING"}}
+	data := []*balanceRow{}
+	addrs := svc.Addresses()
+	state, err := svc.Prefetch(ctx, addrs, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		st, err := addrState(ctx, svc, state, addr)
+		if err != nil {
+			return nil, fmt.Errorf("address %s: %s", addr.ArpStr, err)
+		}
+		balance := st.Balance
+		restricting, err := svc.GetRestrictingValue(ctx, addr.Address)
+		var pposErr *internal.PPOSError
+		if errors.As(err, &pposErr) {
+			restricting, err = big.NewInt(0), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("address %s: %s", addr.ArpStr, err)
+		}
+		row := &balanceRow{Name: addr.Name, Address: addr.ArpStr, Balance: utils.HumReadBalance(balance), Restricting: utils.HumReadBalance(restricting)}
+		data = append(data, row)
+		out.rows = append(out.rows, []string{row.Name, row.Address, row.Balance, row.Restricting})
+	}
+	out.data = data
+	return out, nil
+}
+
+type rewardRow struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Reward  string `json:"reward"`
+}
+
+func rewardsCmd(ctx context.Context, svc internal.SvcImpl) (*output, error) {
+	out := &output{header: []string{"NAME", "ADDRESS", "REWARD"}}
+	data := []*rewardRow{}
+	addrs := svc.Addresses()
+	state, err := svc.Prefetch(ctx, addrs, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		st, err := addrState(ctx, svc, state, addr)
+		if err != nil {
+			return nil, fmt.Errorf("address %s: %s", addr.ArpStr, err)
+		}
+		row := &rewardRow{Name: addr.Name, Address: addr.ArpStr, Reward: utils.HumReadBalance(st.Reward)}
+		data = append(data, row)
+		out.rows = append(out.rows, []string{row.Name, row.Address, row.Reward})
+	}
+	out.data = data
+	return out, nil
+}
+
+// addrState returns the prefetched state of the address, else reads its
+// balance and reward on their own.
+func addrState(ctx context.Context, svc internal.SvcImpl, state map[string]*internal.AddrState, addr *internal.Addr) (st *internal.AddrState, err error) {
+	if st, ok := state[addr.ArpStr]; ok {
+		return st, nil
+	}
+	st = &internal.AddrState{}
+	if st.Balance, err = svc.GetBalance(ctx, addr.ArpStr); err != nil {
+		return
+	}
+	st.Reward, err = svc.ListRewards(ctx, addr)
+	return
+}
+
+func resultOutput(res *internal.Result) *output {
+	out := &output{header: []string{"NAME", "ADDRESS", "HASH", "BLOCK", "GAS USED", "RESULT"}}
+	data := []*internal.ReceiptInfo{}
+	for _, receipt := range res.Receipts {
+		info := receipt.Info()
+		data = append(data, info)
+		result := "ok"
+		switch {
+		case info.Skipped:
+			result = "skipped: " + info.Error
+		case info.Error != "":
+			result = "failed: " + info.Error
+		}
+		out.rows = append(out.rows, []string{info.Name, info.Address, info.Hash,
+			strconv.FormatUint(info.BlockNumber, 10), strconv.FormatUint(info.GasUsed, 10), result})
+	}
+	out.data = data
+	fmt.Fprintln(os.Stderr, res)
+	return out
+}
+
+func withdrawCmd(ctx context.Context, svc internal.SvcImpl) (*output, error) {
+	return resultOutput(svc.WithdrawReward(ctx)), nil
+}
+
+func delegateCmd(ctx context.Context, svc internal.SvcImpl) (*output, error) {
+	return resultOutput(svc.InitDelegate(ctx)), nil
+}
+
+func undelegateCmd(ctx context.Context, svc internal.SvcImpl) (*output, error) {
+	var nodes []conf.Undelegate
+	if nodeID != "" {
+		nodes = append(nodes, conf.Undelegate{NodeID: nodeID, Amount: amount})
+	}
+	return resultOutput(svc.Undelegate(ctx, nodes...)), nil
+}
+
+func compoundCmd(ctx context.Context, svc internal.SvcImpl) (*output, error) {
+	return resultOutput(svc.CompoundReward(ctx)), nil
+}
+
+func migrateCmd(ctx context.Context, svc internal.SvcImpl) (*output, error) {
+	return resultOutput(svc.MigrateDelegation(ctx)), nil
+}
+
+type delegationRow struct {
+	Node            string `json:"node"`
+	StakingBlockNum uint64 `json:"stakingBlockNum"`
+	Amount          string `json:"amount"`
+}
+
+type statusRow struct {
+	Name        string           `json:"name"`
+	Address     string           `json:"address"`
+	Balance     string           `json:"balance"`
+	Reward      string           `json:"reward"`
+	Delegations []*delegationRow `json:"delegations"`
+}
+
+type status struct {
+	BlockNumber int64        `json:"blockNumber"`
+	Epoch       int64        `json:"epoch"`
+	Remain      int64        `json:"remain"`
+	Addrs       []*statusRow `json:"addrs"`
+	// Endpoints is the health of the nodes
+	Endpoints []*client.EndpointStatus `json:"endpoints"`
+}
+
+func statusCmd(ctx context.Context, svc internal.SvcImpl) (*output, error) {
+	number, err := svc.CurrentBlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	st := &status{BlockNumber: number, Addrs: []*statusRow{}, Endpoints: svc.Endpoints()}
+	st.Epoch, st.Remain = svc.EpochOf(ctx, number)
+	fmt.Fprintf(os.Stderr, "block: %d, epoch: %d, remain: %d\n", st.BlockNumber, st.Epoch, st.Remain)
+	for _, e := range st.Endpoints {
+		if e.Healthy {
+			fmt.Fprintf(os.Stderr, "endpoint: %s, priority: %d, block: %d\n", e.URL, e.Priority, e.Height)
+		} else {
+			fmt.Fprintf(os.Stderr, "endpoint: %s, priority: %d, unhealthy: %s\n", e.URL, e.Priority, e.Reason)
+		}
+	}
+
+	out := &output{header: []string{"NAME", "ADDRESS", "BALANCE", "REWARD", "NODE", "STAKING BLOCK", "DELEGATED"}, data: st}
+	addrs := svc.Addresses()
+	state, err := svc.Prefetch(ctx, addrs, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		as, err := addrState(ctx, svc, state, addr)
+		if err != nil {
+			return nil, fmt.Errorf("address %s: %s", addr.ArpStr, err)
+		}
+		row := &statusRow{Name: addr.Name, Address: addr.ArpStr, Balance: utils.HumReadBalance(as.Balance), Reward: utils.HumReadBalance(as.Reward), Delegations: []*delegationRow{}}
+		st.Addrs = append(st.Addrs, row)
+
+		// an address without delegation gets a ppos error
+		related, err := svc.GetRelatedListByDelAddr(ctx, addr.Address)
+		var pposErr *internal.PPOSError
+		if err != nil && !errors.As(err, &pposErr) {
+			return nil, fmt.Errorf("address %s: %s", addr.ArpStr, err)
+		}
+		for _, r := range related {
+			id, err := discv5.HexID(r.NodeID)
+			if err != nil {
+				return nil, fmt.Errorf("address %s: %s", addr.ArpStr, err)
+			}
+			value, err := svc.DelegatedValue(ctx, r.StakingBlockNum, addr.Address, id)
+			if err != nil {
+				return nil, fmt.Errorf("address %s: %s", addr.ArpStr, err)
+			}
+			row.Delegations = append(row.Delegations, &delegationRow{Node: id.String(), StakingBlockNum: r.StakingBlockNum, Amount: utils.HumReadBalance(value)})
+		}
+		if len(row.Delegations) == 0 {
+			out.rows = append(out.rows, []string{row.Name, row.Address, row.Balance, row.Reward, "-", "-", "-"})
+		}
+		for _, d := range row.Delegations {
+			out.rows = append(out.rows, []string{row.Name, row.Address, row.Balance, row.Reward, d.Node[:16], strconv.FormatUint(d.StakingBlockNum, 10), d.Amount})
+		}
+	}
+	return out, nil
+}
+
+func candidatesCmd(ctx context.Context, svc internal.SvcImpl) (*output, error) {
+	candidates, err := svc.GetCandidateList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := &output{header: []string{"NODE", "NAME", "STATUS", "REWARD PER", "VERSION", "DELEGATE TOTAL", "SHARES"}, data: candidates}
+	for _, c := range candidates {
+		node := strings.TrimPrefix(c.NodeID, "0x")
+		if len(node) > 16 {
+			node = node[:16]
+		}
+		out.rows = append(out.rows, []string{node, c.NodeName, strconv.FormatUint(uint64(c.Status), 10),
+			fmt.Sprintf("%.2f%%", float64(c.RewardPer)/100), strconv.FormatUint(uint64(c.ProgramVersion), 10),
+			hexBalance(c.DelegateTotal), hexBalance(c.Shares)})
+	}
+	return out, nil
+}
+
+type selectRow struct {
+	Node          string  `json:"node"`
+	Name          string  `json:"name"`
+	Score         float64 `json:"score"`
+	RewardPer     string  `json:"rewardPer"`
+	DelegateTotal string  `json:"delegateTotal"`
+	Version       uint32  `json:"version"`
+	Pick          bool    `json:"pick"`
+	Reason        string  `json:"reason"`
+}
+
+// selectCmd shows how the strategy ranks the candidates and which nodes the
+// addresses with nodeId auto would delegate to, without sending anything.
+func selectCmd(ctx context.Context, svc internal.SvcImpl) (*output, error) {
+	ranks, err := svc.RankCandidates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	picked := make(map[string]bool)
+	if nodes, err := svc.SelectNodes(ctx); err == nil {
+		for _, n := range nodes {
+			picked[n.ID.String()] = true
+		}
+	}
+	out := &output{header: []string{"NODE", "NAME", "SCORE", "REWARD PER", "DELEGATE TOTAL", "PICK", "REASON"}}
+	data := []*selectRow{}
+	for _, r := range ranks {
+		c := r.Candidate
+		node := strings.TrimPrefix(c.NodeID, "0x")
+		row := &selectRow{Node: node, Name: c.NodeName, Score: r.Score, RewardPer: fmt.Sprintf("%.2f%%", float64(c.RewardPer)/100),
+			DelegateTotal: hexBalance(c.DelegateTotal), Version: c.ProgramVersion, Pick: picked[node], Reason: r.Reason}
+		data = append(data, row)
+		if len(node) > 16 {
+			node = node[:16]
+		}
+		pick := "-"
+		if row.Pick {
+			pick = "yes"
+		}
+		out.rows = append(out.rows, []string{node, row.Name, strconv.FormatFloat(row.Score, 'f', 4, 64), row.RewardPer, row.DelegateTotal, pick, row.Reason})
+	}
+	out.data = data
+	return out, nil
+}
+
+func hexBalance(b *hexutil.Big) string {
+	if b == nil {
+		return "0"
+	}
+	return utils.HumReadBalance(b.ToInt())
+}
+
+type keyRow struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	File    string `json:"file"`
+}
+
+func keysOutput(accounts []accounts.Account) (*output, error) {
+	// name the keystores the config uses
+	names := make(map[string]string)
+	for _, address := range ac.Addrs {
+		if address.Keystore == "" {
+			continue
+		}
+		if path, err := filepath.Abs(address.Keystore); err == nil {
+			names[path] = address.Name
+		}
+	}
+	out := &output{header: []string{"NAME", "ADDRESS", "FILE"}}
+	data := []*keyRow{}
+	for _, a := range accounts {
+		address, err := utils.ConvertAndEncode(ac.Arp, a.Address.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		path, _ := filepath.Abs(a.URL.Path)
+		row := &keyRow{Name: names[path], Address: address, File: a.URL.Path}
+		data = append(data, row)
+		out.rows = append(out.rows, []string{row.Name, row.Address, row.File})
+	}
+	out.data = data
+	return out, nil
+}
+
+// importKeyCmd encrypts a hex private key, read from keyFile or prompted for,
+// into a new keystore in keystoreDir.
+func importKeyCmd(ctx context.Context) (*output, error) {
+	var (
+		key string
+		err error
+	)
+	if keyFile != "" {
+		data, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = strings.TrimSpace(string(data))
+	} else if key, err = internal.ReadSecret("Private key (hex): "); err != nil {
+		return nil, err
+	}
+
+	var passphrase string
+	if ac.PasswordFile != "" || ac.PasswordEnv != "" {
+		passphrase, err = internal.Passphrase(ac.PasswordFile, ac.PasswordEnv, "")
+	} else {
+		passphrase, err = newPassphrase()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := internal.ImportKey(ac.KeystoreDir, key, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return keysOutput([]accounts.Account{account})
+}
+
+func newPassphrase() (string, error) {
+	passphrase, err := internal.ReadSecret("New passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	confirm, err := internal.ReadSecret("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != confirm {
+		return "", errors.New("passphrases do not match")
+	}
+	if passphrase == "" {
+		return "", errors.New("empty passphrase")
+	}
+	return passphrase, nil
+}
+
+// listKeysCmd lists the keystores in keystoreDir.
+func listKeysCmd(ctx context.Context) (*output, error) {
+	return keysOutput(internal.ListKeys(ac.KeystoreDir))
+}
+
+type derivedRow struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Address string `json:"address"`
+	Hex     string `json:"hex"`
+}
+
+// deriveCmd prints the addresses derived from the wallets, to check them
+// against the wallet app.
+func deriveCmd(ctx context.Context) (*output, error) {
+	out := &output{header: []string{"NAME", "PATH", "ADDRESS", "HEX"}}
+	data := []*derivedRow{}
+	for _, wallet := range ac.Wallets {
+		derived, err := internal.DeriveWallet(wallet)
+		if err != nil {
+			return nil, fmt.Errorf("wallet %s: %s", wallet.Name, err)
+		}
+		for _, d := range derived {
+			hex := crypto.PubkeyToAddress(d.Key.PublicKey)
+			address, err := utils.ConvertAndEncode(ac.Arp, hex.Bytes())
+			if err != nil {
+				return nil, err
+			}
+			row := &derivedRow{Name: fmt.Sprintf("%s-%d", wallet.Name, d.Index), Path: d.Path, Address: address, Hex: hex.Hex()}
+			data = append(data, row)
+			out.rows = append(out.rows, []string{row.Name, row.Path, row.Address, row.Hex})
+		}
+	}
+	out.data = data
+	return out, nil
+}
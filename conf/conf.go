@@ -2,21 +2,186 @@ package conf
 
 // Config ...
 type Config struct {
-	ChainID          int64   `json:"chain_id" yaml:"chainId"`
-	Async            *bool   `json:"async" yaml:"async"`
-	RawURL           string  `json:"raw_url" yaml:"rawURL"`
-	Arp              string  `json:"arp" yaml:"arp"`
-	RewardBlock      int64   `json:"reward_block" yaml:"rewardBlock"`
-	DelegateBlock    int64   `json:"delegate_block" yaml:"delegateBlock"`
-	Addrs            []Addr  `json:"addrs" yaml:"addrs"`
-	DstAddr          string  `json:"dst_addr" yaml:"dstAddr"`
-	MinDelegate      float64 `json:"min_delegate" yaml:"minDelegate"`
-	RewardGasLimit   uint64  `json:"reward_gas_limit" yaml:"rewardGasLimit"`
-	DelegateGasLimit uint64  `json:"delegate_gas_limit" yaml:"delegateGasLimit"`
+	ChainID     int64  `json:"chain_id" yaml:"chainId"`
+	Async       *bool  `json:"async" yaml:"async"`
+	RawURL      string `json:"raw_url" yaml:"rawURL"`
+	Arp         string `json:"arp" yaml:"arp"`
+	EpochBlocks int64  `json:"epoch_blocks" yaml:"epochBlocks"`
+	// a task starts when the blocks left in the epoch drop to its block, 0
+	// is its default and a negative block disables it, undelegate and migrate
+	// are disabled by default
+	RewardBlock     int64    `json:"reward_block" yaml:"rewardBlock"`
+	DelegateBlock   int64    `json:"delegate_block" yaml:"delegateBlock"`
+	UndelegateBlock int64    `json:"undelegate_block" yaml:"undelegateBlock"`
+	RedeemBlock     int64    `json:"redeem_block" yaml:"redeemBlock"`
+	SweepBlock      int64    `json:"sweep_block" yaml:"sweepBlock"`
+	Addrs           []Addr   `json:"addrs" yaml:"addrs"`
+	Wallets         []Wallet `json:"wallets" yaml:"wallets"`
+	Strategy        Strategy `json:"strategy" yaml:"strategy"`
+	// FallbackNodes replace the unhealthy nodes of an address, default the
+	// nodes of the strategy
+	FallbackNodes []Node  `json:"fallback_nodes" yaml:"fallbackNodes"`
+	MigrateBlock  int64   `json:"migrate_block" yaml:"migrateBlock"`
+	DstAddr       string  `json:"dst_addr" yaml:"dstAddr"`
+	SweepReserve  float64 `json:"sweep_reserve" yaml:"sweepReserve"`
+	MinDelegate   float64 `json:"min_delegate" yaml:"minDelegate"`
+	// Compound replaces the Reward and Delegate tasks with one that claims
+	// and delegates the balance above CompoundReserve by the DelegateType
+	Compound           bool    `json:"compound" yaml:"compound"`
+	CompoundReserve    float64 `json:"compound_reserve" yaml:"compoundReserve"`
+	RewardGasLimit     uint64  `json:"reward_gas_limit" yaml:"rewardGasLimit"`
+	DelegateGasLimit   uint64  `json:"delegate_gas_limit" yaml:"delegateGasLimit"`
+	UndelegateGasLimit uint64  `json:"undelegate_gas_limit" yaml:"undelegateGasLimit"`
+	RedeemGasLimit     uint64  `json:"redeem_gas_limit" yaml:"redeemGasLimit"`
+	EstimateGas        bool    `json:"estimate_gas" yaml:"estimateGas"`
+	Confirmations      uint64  `json:"confirmations" yaml:"confirmations"`
+	ReceiptTimeout     int64   `json:"receipt_timeout" yaml:"receiptTimeout"`
+	Stuck              Stuck   `json:"stuck" yaml:"stuck"`
+	StateFile          string  `json:"state_file" yaml:"stateFile"`
+	// KeystoreDir is where import-key writes and list-keys reads keystores
+	KeystoreDir string `json:"keystore_dir" yaml:"keystoreDir"`
+	// PasswordFile and PasswordEnv are the default passphrase source of the
+	// keystores, a prompt asks for it when neither is set
+	PasswordFile string `json:"password_file" yaml:"passwordFile"`
+	PasswordEnv  string `json:"password_env" yaml:"passwordEnv"`
+	// Endpoints are more nodes to fail over to, RawURL is the first of them
+	Endpoints []Endpoint `json:"endpoints" yaml:"endpoints"`
+	// MaxBlockLag is how many blocks an endpoint may be behind the highest
+	// one before it is unhealthy, default 10
+	MaxBlockLag uint64 `json:"max_block_lag" yaml:"maxBlockLag"`
+	// ProbeInterval is how often the endpoints are checked, in seconds,
+	// default 15
+	ProbeInterval int64 `json:"probe_interval" yaml:"probeInterval"`
+	Retry         Retry `json:"retry" yaml:"retry"`
+	// GasPrice is the gas price strategy and the fee budget of sync mode
+	GasPrice GasPrice `json:"gas_price" yaml:"gasPrice"`
 }
 
 // Addr ...
 type Addr struct {
-	PrivateKey string `json:"private_key" yaml:"privateKey"`
-	NodeID     string `json:"node_id" yaml:"nodeId"`
+	Name       string       `json:"name" yaml:"name"`
+	PrivateKey string       `json:"private_key" yaml:"privateKey"`
+	NodeID     string       `json:"node_id" yaml:"nodeId"`
+	Nodes      []Node       `json:"nodes" yaml:"nodes"`
+	Undelegate []Undelegate `json:"undelegate" yaml:"undelegate"`
+	// Keystore is an encrypted json key file used instead of PrivateKey, its
+	// passphrase is read from PasswordFile, PasswordEnv or the defaults
+	Keystore     string `json:"keystore" yaml:"keystore"`
+	PasswordFile string `json:"password_file" yaml:"passwordFile"`
+	PasswordEnv  string `json:"password_env" yaml:"passwordEnv"`
+	// Signer is the url of a remote signer holding the key of Address, used
+	// instead of PrivateKey and Keystore
+	Signer  string `json:"signer" yaml:"signer"`
+	Address string `json:"address" yaml:"address"`
+	// DelegateType is free, restricting or mixed, default free
+	DelegateType string `json:"delegate_type" yaml:"delegateType"`
+}
+
+// Wallet derives addresses from a BIP39 mnemonic, read from Mnemonic,
+// MnemonicFile or MnemonicEnv, else prompted for. Path is a BIP44 path
+// template with %d for the index, default m/44'/486'/0'/0/%d, and Count
+// addresses are derived from index Start. They are named <name>-<index> and
+// share the nodes and delegate type.
+type Wallet struct {
+	Name         string `json:"name" yaml:"name"`
+	Mnemonic     string `json:"mnemonic" yaml:"mnemonic"`
+	MnemonicFile string `json:"mnemonic_file" yaml:"mnemonicFile"`
+	MnemonicEnv  string `json:"mnemonic_env" yaml:"mnemonicEnv"`
+	Path         string `json:"path" yaml:"path"`
+	Start        uint32 `json:"start" yaml:"start"`
+	Count        uint32 `json:"count" yaml:"count"`
+	NodeID       string `json:"node_id" yaml:"nodeId"`
+	Nodes        []Node `json:"nodes" yaml:"nodes"`
+	DelegateType string `json:"delegate_type" yaml:"delegateType"`
+}
+
+// NodeAuto as nodeId lets the strategy select the nodes to delegate to.
+const NodeAuto = "auto"
+
+// Strategy selects the nodes of the addresses with nodeId auto: the best
+// Count candidates paying at least MinRewardPer percent, default 1.
+type Strategy struct {
+	Count        int     `json:"count" yaml:"count"`
+	MinRewardPer float64 `json:"min_reward_per" yaml:"minRewardPer"`
+}
+
+// Stuck replaces the transactions not mined within Blocks blocks, default 30
+// and negative to never replace them. Action is replace, resending with a
+// higher gas price, or cancel, sending a zero value transfer to itself with
+// the same nonce instead. The gas price is raised by at least 10% and to the
+// node's price, up to MaxGasPrice gvon, default 100.
+type Stuck struct {
+	Blocks      int64   `json:"blocks" yaml:"blocks"`
+	Action      string  `json:"action" yaml:"action"`
+	MaxGasPrice float64 `json:"max_gas_price" yaml:"maxGasPrice"`
+}
+
+// stuck transaction actions
+const (
+	StuckReplace = "replace"
+	StuckCancel  = "cancel"
+)
+
+// Retry tries an address of the Reward, Delegate and Compound tasks again when
+// it fails to send for a transient or nonce error, up to Attempts times in
+// total, default 3 and negative to never retry. The wait doubles from Backoff
+// up to MaxBackoff seconds, default 5 and 60, and stops at the end of the
+// epoch.
+type Retry struct {
+	Attempts   int   `json:"attempts" yaml:"attempts"`
+	Backoff    int64 `json:"backoff" yaml:"backoff"`
+	MaxBackoff int64 `json:"max_backoff" yaml:"maxBackoff"`
+}
+
+// GasPrice prices the transactions in sync mode. Mode is node, the node's
+// price times Multiplier (default 1), fixed, Price gvon, or percentile, the
+// Percentile of the prices paid in the last Blocks blocks (default 60 and 20).
+// The price is capped at MaxPrice gvon, 0 for no cap. FeeBudget caps the fees
+// of an epoch in LAT/ATP, 0 for no budget: an action that would exceed it is
+// deferred to the next epoch. An action moving less than MinValueRatio times
+// its fee is skipped.
+type GasPrice struct {
+	Mode          string  `json:"mode" yaml:"mode"`
+	Multiplier    float64 `json:"multiplier" yaml:"multiplier"`
+	Price         float64 `json:"price" yaml:"price"`
+	Percentile    int     `json:"percentile" yaml:"percentile"`
+	Blocks        int     `json:"blocks" yaml:"blocks"`
+	MaxPrice      float64 `json:"max_price" yaml:"maxPrice"`
+	FeeBudget     float64 `json:"fee_budget" yaml:"feeBudget"`
+	MinValueRatio float64 `json:"min_value_ratio" yaml:"minValueRatio"`
+}
+
+// gas price modes
+const (
+	GasPriceNode       = "node"
+	GasPriceFixed      = "fixed"
+	GasPricePercentile = "percentile"
+)
+
+// Endpoint is a node the job sends its requests to, the healthy one with the
+// lowest Priority is used.
+type Endpoint struct {
+	URL      string `json:"url" yaml:"url"`
+	Priority int    `json:"priority" yaml:"priority"`
+}
+
+// Node is a node to delegate to, used instead of NodeID to split the balance
+// between several nodes by Weight.
+type Node struct {
+	NodeID string `json:"node_id" yaml:"nodeId"`
+	Weight uint64 `json:"weight" yaml:"weight"`
+}
+
+// delegate types
+const (
+	DelegateFree        = "free"
+	DelegateRestricting = "restricting"
+	DelegateMixed       = "mixed"
+)
+
+// Undelegate is a delegation to withdraw, Amount is in LAT/ATP and 0 withdraws
+// the whole delegation.
+type Undelegate struct {
+	NodeID string  `json:"node_id" yaml:"nodeId"`
+	Amount float64 `json:"amount" yaml:"amount"`
 }
@@ -6,10 +6,13 @@ import (
 	"crypto/ecdsa"
 	"fmt"
 	"math/big"
+	"strings"
 
 	"gitee.com/zonzpoo/platonjob/client"
+	"gitee.com/zonzpoo/platonjob/conf"
 	"gitee.com/zonzpoo/platonjob/utils"
 	"github.com/ethereum/go-ethereum/common"
+	tp "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/p2p/discv5"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -17,37 +20,70 @@ import (
 
 // Addr ...
 type Addr struct {
-	PrivateKey *ecdsa.PrivateKey
-	Address    common.Address
-	ArpStr     string
-	NodeId     discv5.NodeID
+	Name    string
+	Signer  Signer
+	Address common.Address
+	ArpStr  string
+	Nodes   []*Node // nil selects them with the strategy
+
+	DelegateType string
+	Undelegate   []conf.Undelegate
 }
 
-func NewAddr(privateKey, hrp, nodeId string) (addr *Addr, err error) {
-	var (
-		ok bool
-	)
-	addr = &Addr{}
-	addr.PrivateKey, err = crypto.HexToECDSA(privateKey)
+// NewAddr returns the address signed for by signer, delegating to nodes.
+func NewAddr(address common.Address, signer Signer, hrp string, nodes []*Node) (addr *Addr, err error) {
+	addr = &Addr{Address: address, Signer: signer, Nodes: nodes}
+	addr.ArpStr, err = utils.ConvertAndEncode(hrp, addr.Address.Bytes())
 	if err != nil {
 		return
 	}
-	publicKey := addr.PrivateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		err = fmt.Errorf("publicKey is not vaild")
-		return
+	return
+}
+
+// NewKeyAddr returns the address of a private key held in the process.
+func NewKeyAddr(key *ecdsa.PrivateKey, chainID *big.Int, hrp string, nodes []*Node) (addr *Addr, err error) {
+	return NewAddr(crypto.PubkeyToAddress(key.PublicKey), NewLocalSigner(key, chainID), hrp, nodes)
+}
+
+// Node is a node the address delegates to, with its share of the balance.
+type Node struct {
+	ID     discv5.NodeID
+	Weight uint64
+}
+
+func (n *Node) String() string {
+	return fmt.Sprintf("%s:%d", n.ID.TerminalString(), n.Weight)
+}
+
+// parseNodes returns the weighted nodes of the config, or nodeID alone when
+// no nodes are listed. No nodes are returned for conf.NodeAuto, the strategy
+// selects them.
+func parseNodes(nodeID string, nodes []conf.Node) ([]*Node, error) {
+	if nodeID == conf.NodeAuto && len(nodes) == 0 {
+		return nil, nil
 	}
-	addr.Address = crypto.PubkeyToAddress(*publicKeyECDSA)
-	addr.ArpStr, err = utils.ConvertAndEncode(hrp, addr.Address.Bytes())
-	if err != nil {
-		return
+	if len(nodes) == 0 {
+		nodes = []conf.Node{{NodeID: nodeID, Weight: 1}}
+	} else if nodeID != "" {
+		return nil, fmt.Errorf("both nodeId and nodes are set")
 	}
-	addr.NodeId, err = discv5.HexID(nodeId)
-	if err != nil {
-		return
+	parsed := make([]*Node, 0, len(nodes))
+	for _, n := range nodes {
+		id, err := discv5.HexID(n.NodeID)
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %s", n.NodeID, err)
+		}
+		if n.Weight == 0 {
+			return nil, fmt.Errorf("node %s: weight is 0", n.NodeID)
+		}
+		parsed = append(parsed, &Node{ID: id, Weight: n.Weight})
 	}
-	return
+	return parsed, nil
+}
+
+// SignTx signs tx with the signer of the address.
+func (d *Addr) SignTx(ctx context.Context, tx *tp.Transaction) (*tp.Transaction, error) {
+	return d.Signer.SignTx(ctx, tx)
 }
 
 func (d *Addr) RewardMsg(ctx context.Context, arp string) (msg client.CallMsg, err error) {
@@ -74,11 +110,9 @@ func (d *Addr) RewardMsg(ctx context.Context, arp string) (msg client.CallMsg, e
 		return
 	}
 	msg = client.CallMsg{
-		From:     arpStr,
-		To:       contractAddr,
-		Gas:      103496,
-		GasPrice: big.NewInt(500000000000),
-		Data:     buf,
+		From: arpStr,
+		To:   contractAddr,
+		Data: buf,
 	}
 	return
 }
@@ -111,6 +145,20 @@ func (d *Addr) bufData(rewardCode int64, nodes *[]discv5.NodeID, address common.
 	return
 }
 
+// Match reports whether the address is selected by the filter, an empty
+// filter selects everything. Entries are names, bech32 or hex addresses.
+func (d *Addr) Match(filter []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, f := range filter {
+		if f == d.Name || strings.EqualFold(f, d.ArpStr) || strings.EqualFold(f, d.Address.Hex()) {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *Addr) toArp(ctx context.Context, arp string) (arpStr string, err error) {
 	return utils.ConvertAndEncode(arp, d.Address.Bytes())
 }
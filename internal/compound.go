@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"k8s.io/klog"
+
+	"gitee.com/zonzpoo/platonjob/utils"
+)
+
+// default balance kept on every address when compounding, in LAT/ATP
+const defaultCompoundReserve = 0.1
+
+// Compound claims the reward of every address, waits for the claim and
+// delegates the balance above the reserve, the claimed amount included, by
+// the delegate type of the address in one chain of transactions.
+type Compound struct {
+	*Delegate
+}
+
+func (c *Compound) sendTransactions(addr *Addr) (txs []*tp.Transaction, err error) {
+	nodes, err := c.nodes(addr)
+	if err != nil {
+		err = fmt.Errorf("[Compound sendTransactions] current address %s select nodes failed %s", addr.ArpStr, err)
+		return
+	}
+
+	claimed := big.NewInt(0)
+	reward, err := c.reward(addr)
+	if err != nil {
+		err = fmt.Errorf("[Compound sendTransactions] current address: %s, list reward error: %s", addr.ArpStr, err)
+		return
+	}
+	if reward.Cmp(big.NewInt(utils.BaseVon)) >= 0 {
+		var nonce uint64
+		if nonce, err = c.NextNonce(c.ctx, addr); err != nil {
+			err = fmt.Errorf("[Compound sendTransactions] current address: %s, get nonce error: %s", addr.ArpStr, err)
+			return
+		}
+		var tx *tp.Transaction
+		tx, err = c.RunReward(c.ctx, addr, reward, nonce)
+		if err != nil {
+			err = fmt.Errorf("[Compound sendTransactions] current address %s get reward failed %w", addr.ArpStr, err)
+			return
+		}
+		var receipt *Receipt
+		receipt, claimed = c.claimed(addr, tx)
+		if receipt.tx != nil {
+			tx = receipt.tx
+		}
+		// the claim is reported with the receipt waited for here
+		c.keepWaited(tx, receipt)
+		txs = append(txs, tx)
+		if receipt.err != nil {
+			return
+		}
+		klog.Infof("[Compound sendTransactions] current address: %s, claimed: %s", addr.ArpStr, utils.HumReadBalance(claimed))
+	}
+
+	sent, err := c.delegateBalance(addr, nodes)
+	txs = append(txs, sent...)
+	if err == nil && len(txs) == 0 {
+		err = skipf("[Compound sendTransactions] current address: %s, reward: %s, nothing to delegate", addr.ArpStr, utils.HumReadBalance(reward))
+	}
+	return
+}
+
+// delegateBalance delegates by the delegate type of the address, the free
+// amount is the balance above the reserve.
+func (c *Compound) delegateBalance(addr *Addr, nodes []*Node) (txs []*tp.Transaction, err error) {
+	txs, _, err = c.delegateByType(addr, nodes, func() (*big.Int, error) {
+		balance, err := c.GetBalance(c.ctx, addr.ArpStr)
+		if err != nil {
+			return nil, fmt.Errorf("get balance error: %s", err)
+		}
+		amount := new(big.Int).Sub(balance, c.CompoundReserveVon())
+		if amount.Sign() < 0 {
+			amount.SetInt64(0)
+		}
+		klog.Infof("[Compound sendTransactions] current address: %s, delegate value: %s", addr.ArpStr, utils.HumReadBalance(amount))
+		return amount, nil
+	})
+	return
+}
+
+// afterClaim delegates the balance when the transactions an earlier run sent
+// were only its claim, the run stopped before delegating what it claimed.
+func (c *Compound) afterClaim(addr *Addr, receipts []*Receipt) ([]*tp.Transaction, error) {
+	for _, r := range receipts {
+		if r.err != nil || r.tx == nil || pposFnType(r.tx.Data()) != rewardCode {
+			return nil, nil
+		}
+	}
+	nodes, err := c.nodes(addr)
+	if err != nil {
+		return nil, fmt.Errorf("[Compound afterClaim] current address %s select nodes failed %s", addr.ArpStr, err)
+	}
+	klog.Infof("[Compound afterClaim] current address: %s, claim of an earlier run confirmed, delegate the balance", addr.ArpStr)
+	return c.delegateBalance(addr, nodes)
+}
+
+// claimed waits for the claim and returns its receipt, with the claim that
+// was mined when the stuck one was replaced, and the reward it paid out.
+func (c *Compound) claimed(addr *Addr, tx *tp.Transaction) (*Receipt, *big.Int) {
+	r, replaced, err := c.wait(addr, tx.Hash(), tx)
+	receipt := c.outcome(addr, tx.Hash(), tx, r, replaced, err)
+	if receipt.err != nil {
+		return receipt, nil
+	}
+	claimed, err := DecodeClaimedReward(r.Logs)
+	if err != nil {
+		receipt.err = fmt.Errorf("[Compound claimed] current address: %s, decode claimed reward error: %s", addr.ArpStr, err)
+		return receipt, nil
+	}
+	return receipt, claimed
+}
+
+// CompoundReserveVon returns the balance kept on every address when
+// compounding, in von.
+func (s *Service) CompoundReserveVon() *big.Int {
+	if s.CompoundReserve <= 0 {
+		return utils.ToVon(defaultCompoundReserve)
+	}
+	return utils.ToVon(s.CompoundReserve)
+}
+
+// CompoundReward claims and delegates the reward of every address and waits
+// for the receipts.
+func (s *Service) CompoundReward(ctx context.Context) *Result {
+	compound := &Compound{Delegate: newDelegate(ctx, s)}
+	compound.worker = newWorker(ctx, s, "Compound", s.newAddrs(), compound.sendTransactions)
+	compound.worker.resumed = compound.afterClaim
+	compound.rewards, compound.retry = true, s.RetryPolicy()
+	return compound.Start()
+}
@@ -3,11 +3,12 @@ package internal
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
-	"sync/atomic"
-	"time"
+	"sync"
 
+	"gitee.com/zonzpoo/platonjob/conf"
 	"gitee.com/zonzpoo/platonjob/utils"
 	"github.com/ethereum/go-ethereum/common"
 	tp "github.com/ethereum/go-ethereum/core/types"
@@ -21,144 +22,211 @@ const (
 	delegateCode = int64(1004)
 )
 
+// delegation source of 1004
+const (
+	delegateFree        = uint16(0)
+	delegateRestricting = uint16(1)
+)
+
+// Delegate ...
 type Delegate struct {
-	SvcImpl
+	*worker
+	health NodeHealth // nil when the candidate list could not be read
 
-	ctx   context.Context
-	addrs []*Addr
+	selected *lazyNodes // for the addresses with nodeId auto
+	fallback *lazyNodes // for the addresses without a healthy node
+}
 
-	send    chan *Addr
-	receipt chan *Receipt
+// lazyNodes are nodes looked up once, on first use.
+type lazyNodes struct {
+	once  *sync.Once
+	nodes []*Node
+	err   error
+}
 
-	receipts int32
-	total    int32
+func newLazyNodes() *lazyNodes {
+	return &lazyNodes{once: &sync.Once{}}
+}
 
-	exit chan struct{}
+func (l *lazyNodes) get(lookup func() ([]*Node, error)) ([]*Node, error) {
+	l.once.Do(func() {
+		l.nodes, l.err = lookup()
+	})
+	return l.nodes, l.err
 }
 
-func (d *Delegate) Start() {
-	go d.report()
-	go d.run()
+// nodes returns the healthy nodes of the address, or the nodes of the
+// strategy for nodeId auto. When none of them is healthy the fallback nodes
+// are used.
+func (d *Delegate) nodes(addr *Addr) ([]*Node, error) {
+	nodes := addr.Nodes
+	if nodes == nil {
+		var err error
+		if nodes, err = d.selected.get(func() ([]*Node, error) { return d.SelectNodes(d.ctx) }); err != nil {
+			return nil, err
+		}
+	}
+	if healthy := d.health.Healthy(nodes); len(healthy) > 0 {
+		return healthy, nil
+	}
+	klog.Warningf("[Delegate sendTransactions] current address: %s, no healthy node, delegate to the fallback nodes", addr.ArpStr)
+	fallback, err := d.fallback.get(func() ([]*Node, error) { return d.FallbackNodes(d.ctx) })
+	if err != nil {
+		return nil, err
+	}
+	if healthy := d.health.Healthy(fallback); len(healthy) > 0 {
+		return healthy, nil
+	}
+	return nil, fmt.Errorf("no healthy node to delegate to")
+}
 
-	for _, addr := range d.addrs {
-		d.send <- addr
-		time.Sleep(100 * time.Millisecond)
+func (d *Delegate) sendTransactions(addr *Addr) (txs []*tp.Transaction, err error) {
+	nodes, err := d.nodes(addr)
+	if err != nil {
+		err = fmt.Errorf("[Delegate sendTransactions] current address %s select nodes failed %s", addr.ArpStr, err)
+		return
+	}
+	txs, value, err := d.delegateByType(addr, nodes, func() (*big.Int, error) {
+		balance, err := d.balance(addr)
+		if err != nil {
+			return nil, fmt.Errorf("get delegate value error: %s", err)
+		}
+		realdelegateValue, _ := delegateValue(balance).Int(big.NewInt(0))
+		return realdelegateValue, nil
+	})
+	if err == nil && len(txs) == 0 {
+		if addr.DelegateType == conf.DelegateRestricting {
+			err = skipf("[Delegate sendTransactions] current address: %s, restricting value: %s", addr.ArpStr, utils.HumReadBalance(value))
+		} else {
+			err = skipf("[Delegate sendTransactions] current address: %s, delegate value: %s", addr.ArpStr, utils.HumReadBalance(value))
+		}
 	}
+	return
 }
 
-func (d *Delegate) report() {
-	timeout := time.After(time.Duration(d.total) * 2 * time.Second)
-	t := time.NewTicker(time.Millisecond * 500)
-	for {
-		select {
-		case <-t.C:
-			klog.Infof("[Delegate report] current total: %d, receipts: %d", d.total, d.receipts)
-			if d.total == d.receipts {
-				close(d.exit)
-			}
-		case <-timeout:
-			klog.Infof("[Delegate report] timeout, total: %d", d.total)
-			close(d.exit)
-		case <-d.ctx.Done():
-			close(d.exit)
-		case <-d.exit:
+// delegateByType delegates by the DelegateType of the address: the
+// restricting plan, the free amount read by free, or both, restricting
+// first. value is the last amount delegated.
+func (d *Delegate) delegateByType(addr *Addr, nodes []*Node, free func() (*big.Int, error)) (txs []*tp.Transaction, value *big.Int, err error) {
+	// mixed mode spends the restricting balance first, the lock-up plan can not be used for anything else
+	if addr.DelegateType == conf.DelegateRestricting || addr.DelegateType == conf.DelegateMixed {
+		value, err = d.GetRestrictingValue(d.ctx, addr.Address)
+		// an address without lock-up plan gets a ppos error, it has nothing to delegate
+		var pposErr *PPOSError
+		if errors.As(err, &pposErr) {
+			klog.Infof("[Delegate sendTransactions] current address: %s, no restricting plan: %s", addr.ArpStr, err)
+			value, err = big.NewInt(0), nil
+		}
+		if err != nil {
+			err = fmt.Errorf("[Delegate sendTransactions] current address: %s, get restricting value error: %s", addr.ArpStr, err)
+			return
+		}
+		var sent []*tp.Transaction
+		sent, err = d.delegate(addr, nodes, delegateRestricting, value)
+		txs = append(txs, sent...)
+		if err != nil || addr.DelegateType == conf.DelegateRestricting {
 			return
 		}
 	}
-}
 
-func (d *Delegate) add() {
-	atomic.AddInt32(&d.receipts, 1)
+	if value, err = free(); err != nil {
+		err = fmt.Errorf("[Delegate sendTransactions] current address: %s, %s", addr.ArpStr, err)
+		return
+	}
+	sent, err := d.delegate(addr, nodes, delegateFree, value)
+	txs = append(txs, sent...)
+	return
 }
 
-func (d *Delegate) run() {
-	for {
-		select {
-		case addr := <-d.send:
-			klog.Infof("[Delegate run] receive address: %s, begin send transaction", addr.ArpStr)
-			go d.sendTransaction(addr)
-		case receipt := <-d.receipt:
-			if receipt.err != nil {
-				klog.Errorf("[Reward run] current address: %s, get initiate delegate err: %s", receipt.addr.ArpStr, receipt.err)
-			} else {
-				if d.IsAsync() {
-					klog.Infof("[Delegate run] current address: %s", receipt.addr.ArpStr)
-				} else {
-					klog.Infof("[Delegate run] current address: %s, get initiate delegate hash tx: %s", receipt.addr.ArpStr, receipt.tx.Hash().Hex())
-				}
-			}
-			go d.add()
-		case <-d.exit:
+// delegate splits amount between the nodes and sends one 1004 per node.
+func (d *Delegate) delegate(addr *Addr, nodes []*Node, typ uint16, amount *big.Int) (txs []*tp.Transaction, err error) {
+	parts := splitDelegation(amount, d.MinVon(), nodes)
+	if len(parts) == 0 {
+		klog.Infof("[Delegate sendTransactions] current address: %s, type: %d, value: %s below minimum", addr.ArpStr, typ, utils.HumReadBalance(amount))
+		return
+	}
+	for _, part := range parts {
+		var nonce uint64
+		if nonce, err = d.NextNonce(d.ctx, addr); err != nil {
+			err = fmt.Errorf("[Delegate sendTransactions] current address: %s, get nonce error: %s", addr.ArpStr, err)
 			return
 		}
+		var tx *tp.Transaction
+		tx, err = d.RunDelegate(d.ctx, part.node.ID, typ, part.amount, addr, nonce)
+		if err != nil {
+			err = fmt.Errorf("[Delegate sendTransactions] current address %s run delegate type %d to %s failed %w", addr.ArpStr, typ, part.node.ID.TerminalString(), err)
+			return
+		}
+		klog.Infof("[Delegate sendTransactions] finished send delegate, current address: %s, type: %d, node: %s, value: %s, nonce: %d",
+			addr.ArpStr, typ, part.node.ID.TerminalString(), utils.HumReadBalance(part.amount), nonce)
+		txs = append(txs, tx)
 	}
+	return
 }
 
-func (d *Delegate) sendTransaction(addr *Addr) {
-	var (
-		tx  *tp.Transaction
-		err error
-	)
+type delegation struct {
+	node   *Node
+	amount *big.Int
+}
 
-	defer func() {
-		<-time.After(time.Second)
-		d.receipt <- &Receipt{
-			addr: addr,
-			tx:   tx,
-			err:  err,
+// splitDelegation splits amount between the nodes by weight, the rounding
+// remainder goes to the first node. When a part is below min the node with
+// the smallest weight among them is dropped and the amount split again, so
+// nothing is delegated when amount itself is below min.
+func splitDelegation(amount, min *big.Int, nodes []*Node) []*delegation {
+	nodes = append([]*Node{}, nodes...)
+	for len(nodes) > 0 {
+		total := new(big.Int)
+		for _, n := range nodes {
+			total.Add(total, new(big.Int).SetUint64(n.Weight))
+		}
+		parts := make([]*delegation, 0, len(nodes))
+		remain := new(big.Int).Set(amount)
+		for _, n := range nodes {
+			part := new(big.Int).Mul(amount, new(big.Int).SetUint64(n.Weight))
+			part.Quo(part, total)
+			remain.Sub(remain, part)
+			parts = append(parts, &delegation{node: n, amount: part})
 		}
-	}()
+		parts[0].amount.Add(parts[0].amount, remain)
 
-	nonce, err := d.GetNonce(d.ctx, addr.ArpStr)
-	if err != nil {
-		err = fmt.Errorf("[Delegate sendTransaction] current address: %s, get nonce error: %s", addr.ArpStr, err)
-		return
+		drop := -1
+		for i, p := range parts {
+			if p.amount.Cmp(min) < 0 && (drop < 0 || p.node.Weight <= nodes[drop].Weight) {
+				drop = i
+			}
+		}
+		if drop < 0 {
+			return parts
+		}
+		nodes = append(nodes[:drop], nodes[drop+1:]...)
 	}
+	return nil
+}
 
-	baseVon := big.NewFloat(0).SetFloat64(utils.BaseVon)
-	delegateValue, err := d.GetDelegateValue(d.ctx, addr.ArpStr)
-	if err != nil {
-		err = fmt.Errorf("[Delegate sendTransaction] current address: %s, get delegate value error: %s", addr.ArpStr, err)
-		return
-	}
-	if delegateValue.Cmp(d.MinVon()) == -1 {
-		err = fmt.Errorf("[Delegate sendTransaction] current address: %s, delegate value: %s", addr.ArpStr, big.NewFloat(0).Quo(delegateValue, baseVon).String())
-		return
-	}
-	realdelegateValue, _ := delegateValue.Int(big.NewInt(0))
-	tx, err = d.RunDelegate(d.ctx, addr.NodeId, realdelegateValue, addr, nonce)
+// newDelegate reads the node health for a delegation run, the worker is set
+// by the caller.
+func newDelegate(ctx context.Context, s *Service) *Delegate {
+	health, err := s.NodeHealth(ctx)
 	if err != nil {
-		err = fmt.Errorf("[Delegate sendTransaction] current address %s run delegate failed %s", addr.ArpStr, err)
-		return
+		klog.Warningf("[Delegate] get candidate list error: %s, delegate without health check", err)
 	}
-	klog.Infof("[Delegate sendTransaction] finished send delegate, current address: %s, nonce: %d", addr.ArpStr, nonce)
+	return &Delegate{health: health, selected: newLazyNodes(), fallback: newLazyNodes()}
 }
 
-func (s *Service) InitDelegate(ctx context.Context) {
-	addrs := []*Addr{}
-	for _, address := range s.Addrs {
-		addr, err := NewAddr(address.PrivateKey, s.Arp, address.NodeID)
-		if err != nil {
-			panic(err)
-		}
-		addrs = append(addrs, addr)
-	}
-	delegate := &Delegate{
-		SvcImpl: s,
-		ctx:     ctx,
-		addrs:   addrs,
-
-		send:    make(chan *Addr, len(addrs)),
-		receipt: make(chan *Receipt, len(addrs)),
-
-		receipts: 0,
-		total:    int32(len(addrs)),
-		exit:     make(chan struct{}),
-	}
-	go delegate.Start()
+// InitDelegate delegates the spendable balance of every address to its
+// healthy nodes and waits for the receipts.
+func (s *Service) InitDelegate(ctx context.Context) *Result {
+	delegate := newDelegate(ctx, s)
+	delegate.worker = newWorker(ctx, s, "Delegate", s.newAddrs(), delegate.sendTransactions)
+	delegate.retry = s.RetryPolicy()
+	return delegate.Start()
 }
 
-func (s *Service) RunDelegate(ctx context.Context, nodeID discv5.NodeID, amount *big.Int, addr *Addr, nonce uint64) (tx *tp.Transaction, err error) {
+// RunDelegate delegates amount to the node, typ selects the free balance
+// (delegateFree) or the restricting plan (delegateRestricting) as the source.
+func (s *Service) RunDelegate(ctx context.Context, nodeID discv5.NodeID, typ uint16, amount *big.Int, addr *Addr, nonce uint64) (tx *tp.Transaction, err error) {
+	defer s.resetNonceOnError(addr, &err)
 	var (
 		gasPrice *big.Int
 	)
@@ -168,41 +236,46 @@ func (s *Service) RunDelegate(ctx context.Context, nodeID discv5.NodeID, amount
 		return
 	}
 
-	buf, err := s.delegateBufData(nodeID, amount)
+	buf, err := s.delegateBufData(typ, nodeID, amount)
+	if err != nil {
+		return
+	}
+	gasPrice, err = s.TxGasPrice(ctx)
 	if err != nil {
 		return
 	}
-	gasPrice, err = s.client.GasPrice(ctx)
+
+	gasLimit, err := s.gasLimit(ctx, addr, delegateCode, buf)
 	if err != nil {
 		return
 	}
-	if s.IsAsync() {
-		gasPrice = big.NewInt(0)
+	fee, err := s.reserveFee(ctx, addr, gasLimit, gasPrice, amount)
+	if err != nil {
+		return
 	}
+	defer s.releaseFeeOnError(fee, &err)
 
-	tx, err = tp.SignTx(
+	tx, err = addr.SignTx(ctx,
 		tp.NewTransaction(
 			nonce,
 			common.HexToAddress(address),
 			big.NewInt(1),
-			s.DelegateGasLimit,
+			gasLimit,
 			gasPrice,
-			buf),
-		s.signer,
-		addr.PrivateKey)
+			buf))
 	if err != nil {
 		return
 	}
-	err = s.client.SendTransaction(ctx, tx)
+	err = s.sendTx(ctx, addr, tx)
 	return
 }
 
-func (s *Service) delegateBufData(node discv5.NodeID, amount *big.Int) (buf []byte, err error) {
+func (s *Service) delegateBufData(delegateType uint16, node discv5.NodeID, amount *big.Int) (buf []byte, err error) {
 	fnType, err := rlp.EncodeToBytes(uint16(delegateCode))
 	if err != nil {
 		return
 	}
-	typ, err := rlp.EncodeToBytes(uint16(0))
+	typ, err := rlp.EncodeToBytes(delegateType)
 	if err != nil {
 		return
 	}
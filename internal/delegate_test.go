@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p/discv5"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"gitee.com/zonzpoo/platonjob/conf"
+)
+
+func TestSplitDelegation(t *testing.T) {
+	nodes := []*Node{
+		{ID: discv5.NodeID{1}, Weight: 50},
+		{ID: discv5.NodeID{2}, Weight: 30},
+		{ID: discv5.NodeID{3}, Weight: 20},
+	}
+	tests := []struct {
+		amount, min int64
+		want        map[byte]int64
+	}{
+		{amount: 100, min: 10, want: map[byte]int64{1: 50, 2: 30, 3: 20}},
+		// the rounding remainder goes to the first node
+		{amount: 101, min: 10, want: map[byte]int64{1: 51, 2: 30, 3: 20}},
+		// 20% is below min, split again 50/30
+		{amount: 40, min: 10, want: map[byte]int64{1: 25, 2: 15}},
+		{amount: 15, min: 10, want: map[byte]int64{1: 15}},
+		{amount: 9, min: 10, want: map[byte]int64{}},
+	}
+	for _, tt := range tests {
+		parts := splitDelegation(big.NewInt(tt.amount), big.NewInt(tt.min), nodes)
+		got := make(map[byte]int64)
+		for _, p := range parts {
+			got[p.node.ID[0]] = p.amount.Int64()
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("amount %d: got %v, want %v", tt.amount, got, tt.want)
+			continue
+		}
+		for id, amount := range tt.want {
+			if got[id] != amount {
+				t.Errorf("amount %d: got %v, want %v", tt.amount, got, tt.want)
+				break
+			}
+		}
+	}
+	if len(nodes) != 3 {
+		t.Errorf("nodes modified: %d left", len(nodes))
+	}
+}
+
+func TestDelegateBufData(t *testing.T) {
+	s := &Service{}
+	for _, typ := range []uint16{delegateFree, delegateRestricting} {
+		buf, err := s.delegateBufData(typ, discv5.NodeID{1}, big.NewInt(10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var params [][]byte
+		if err = rlp.DecodeBytes(buf, &params); err != nil || len(params) != 4 {
+			t.Fatalf("type %d: got %d params, %v", typ, len(params), err)
+		}
+		var fn, got uint16
+		if err = rlp.DecodeBytes(params[0], &fn); err != nil || fn != uint16(delegateCode) {
+			t.Errorf("type %d: got function %d, %v", typ, fn, err)
+		}
+		if err = rlp.DecodeBytes(params[1], &got); err != nil || got != typ {
+			t.Errorf("type %d: got type %d, %v", typ, got, err)
+		}
+	}
+}
+
+// delegateSvc records the delegations sent.
+type delegateSvc struct {
+	SvcImpl
+	restricting *big.Int
+	sent        []string
+}
+
+func (s *delegateSvc) GetRestrictingValue(ctx context.Context, address common.Address) (*big.Int, error) {
+	return s.restricting, nil
+}
+
+func (s *delegateSvc) MinVon() *big.Int {
+	return big.NewInt(10)
+}
+
+func (s *delegateSvc) NextNonce(ctx context.Context, addr *Addr) (uint64, error) {
+	return uint64(len(s.sent)), nil
+}
+
+func (s *delegateSvc) RunDelegate(ctx context.Context, nodeID discv5.NodeID, typ uint16, amount *big.Int, addr *Addr, nonce uint64) (*tp.Transaction, error) {
+	s.sent = append(s.sent, fmt.Sprintf("%d:%s", typ, amount))
+	return tp.NewTransaction(nonce, common.Address{}, amount, 0, nil, nil), nil
+}
+
+func TestDelegateByType(t *testing.T) {
+	nodes := []*Node{{ID: discv5.NodeID{1}, Weight: 1}}
+	tests := []struct {
+		typ  string
+		want []string
+	}{
+		{typ: conf.DelegateFree, want: []string{"0:50"}},
+		{typ: conf.DelegateRestricting, want: []string{"1:20"}},
+		// the restricting plan is spent first
+		{typ: conf.DelegateMixed, want: []string{"1:20", "0:50"}},
+	}
+	for _, tt := range tests {
+		svc := &delegateSvc{restricting: big.NewInt(20)}
+		d := &Delegate{worker: &worker{SvcImpl: svc, ctx: context.Background()}}
+		addr := &Addr{ArpStr: "lat1", DelegateType: tt.typ}
+		txs, _, err := d.delegateByType(addr, nodes, func() (*big.Int, error) { return big.NewInt(50), nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(svc.sent) != fmt.Sprint(tt.want) || len(txs) != len(tt.want) {
+			t.Errorf("%s: sent %v, want %v", tt.typ, svc.sent, tt.want)
+		}
+	}
+}
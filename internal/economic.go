@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"k8s.io/klog"
+
+	"gitee.com/zonzpoo/platonjob/utils/types"
+)
+
+// blocks of a settlement epoch on the PlatON mainnet, used until the economic
+// config of the chain is known
+const defaultEpochBlocks = int64(10750)
+
+// getGovernParamValue of the governance contract
+const governParamCode = int64(2106)
+
+// economic caches the economic config of the chain, refreshed every epoch.
+type economic struct {
+	lock *sync.RWMutex
+
+	config      *types.EconomicConfig
+	epochBlocks int64
+	epoch       int64 // epoch the config was loaded in
+}
+
+func newEconomic() *economic {
+	return &economic{lock: &sync.RWMutex{}}
+}
+
+// EconomicConfig returns the cached economic config of the chain.
+func (s *Service) EconomicConfig(ctx context.Context) (*types.EconomicConfig, error) {
+	s.econ.lock.RLock()
+	config, loaded := s.econ.config, s.econ.epoch
+	s.econ.lock.RUnlock()
+	if config != nil {
+		return config, nil
+	}
+	return s.refreshEconomic(ctx, loaded)
+}
+
+// governParam returns the value of the governable parameter name of module.
+func (s *Service) governParam(ctx context.Context, module, name string) (value string, err error) {
+	err = s.query(ctx, governParamCode, &value, module, name)
+	return
+}
+
+// governEconomic reads the staking parameters from the governance contract.
+func (s *Service) governEconomic(ctx context.Context) (*types.EconomicConfig, error) {
+	value, err := s.governParam(ctx, "staking", "unDelegateFreezeDuration")
+	if err != nil {
+		return nil, err
+	}
+	freeze, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unDelegateFreezeDuration %q: %s", value, err)
+	}
+	config := new(types.EconomicConfig)
+	config.Staking.UnDelegateFreezeDuration = freeze
+	return config, nil
+}
+
+// EpochBlocks returns the blocks of a settlement epoch.
+func (s *Service) EpochBlocks() int64 {
+	s.econ.lock.RLock()
+	defer s.econ.lock.RUnlock()
+	if s.econ.epochBlocks > 0 {
+		return s.econ.epochBlocks
+	}
+	if s.Config.EpochBlocks > 0 {
+		return s.Config.EpochBlocks
+	}
+	return defaultEpochBlocks
+}
+
+// refreshEconomic loads the economic config of the chain in epoch.
+func (s *Service) refreshEconomic(ctx context.Context, epoch int64) (config *types.EconomicConfig, err error) {
+	s.econ.lock.Lock()
+	defer s.econ.lock.Unlock()
+	// mark the epoch first, a node without the debug api is asked once per epoch
+	s.econ.epoch = epoch
+
+	data, err := s.client.EconomicConfig(ctx)
+	if err != nil {
+		klog.Warningf("[refreshEconomic] get economic config error: %s, epoch blocks: %d", err, s.Config.EpochBlocks)
+		if config, err = s.governEconomic(ctx); err != nil {
+			klog.Warningf("[refreshEconomic] get govern params error: %s", err)
+			return
+		}
+		s.econ.config = config
+		return
+	}
+	config = new(types.EconomicConfig)
+	if err = json.Unmarshal(data, config); err != nil {
+		err = fmt.Errorf("invalid economic config: %s", err)
+		return
+	}
+	blocks := int64(config.EpochBlocks())
+	if blocks == 0 {
+		err = fmt.Errorf("invalid economic config: %s", data)
+		return
+	}
+	if s.Config.EpochBlocks > 0 && s.Config.EpochBlocks != blocks {
+		klog.Warningf("[refreshEconomic] configured epoch blocks %d disagree with the chain %d, use the chain", s.Config.EpochBlocks, blocks)
+	}
+	if s.econ.epochBlocks != 0 && s.econ.epochBlocks != blocks {
+		klog.Warningf("[refreshEconomic] epoch blocks changed from %d to %d", s.econ.epochBlocks, blocks)
+	}
+	s.econ.config, s.econ.epochBlocks = config, blocks
+	return
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p/discv5"
+	"k8s.io/klog"
+
+	"gitee.com/zonzpoo/platonjob/client"
+	"gitee.com/zonzpoo/platonjob/utils"
+)
+
+// gas of the built-in PPOS transactions, the base and data gas of any
+// transaction plus the gas of the function
+const (
+	txGas            = uint64(21000)
+	txDataZeroGas    = uint64(4)
+	txDataNonZeroGas = uint64(68)
+
+	stakingGas            = uint64(6000) // every staking function
+	delegateGas           = uint64(16000)
+	withdrewDelegationGas = uint64(8000)
+	// the economic model lists no gas for 1006, it is taken as the 8000 of
+	// 1005 and always checked with the node's estimate, see gasLimit
+	redeemDelegationGas = uint64(8000)
+
+	withdrawRewardGas      = uint64(8000)
+	withdrawRewardNodeGas  = uint64(1000) // per delegated node
+	withdrawRewardEpochGas = uint64(100)  // per epoch since the last claim, per node
+)
+
+// dataGas returns the base gas of a transaction with the data.
+func dataGas(data []byte) uint64 {
+	gas := txGas
+	for _, b := range data {
+		if b == 0 {
+			gas += txDataZeroGas
+		} else {
+			gas += txDataNonZeroGas
+		}
+	}
+	return gas
+}
+
+// PPOSGas returns the gas a PPOS transaction of fnType with the encoded data
+// uses. nodes and epochs only count for claiming the reward: the delegated
+// nodes and the epochs since each delegation was last settled, summed.
+func PPOSGas(fnType int64, data []byte, nodes, epochs uint64) (uint64, error) {
+	gas := dataGas(data)
+	switch fnType {
+	case delegateCode:
+		gas += stakingGas + delegateGas
+	case withdrawDelegateCode:
+		gas += stakingGas + withdrewDelegationGas
+	case redeemCode:
+		gas += stakingGas + redeemDelegationGas
+	case rewardCode:
+		gas += withdrawRewardGas + nodes*withdrawRewardNodeGas + epochs*withdrawRewardEpochGas
+	default:
+		return 0, fmt.Errorf("no gas formula for function %d", fnType)
+	}
+	return gas, nil
+}
+
+// gasOverride returns the configured gas limit of the function, 0 when the
+// gas is computed.
+func (s *Service) gasOverride(fnType int64) uint64 {
+	switch fnType {
+	case rewardCode:
+		return s.RewardGasLimit
+	case delegateCode:
+		return s.DelegateGasLimit
+	case withdrawDelegateCode:
+		return s.UndelegateGasLimit
+	case redeemCode:
+		if s.RedeemGasLimit == 0 {
+			return s.UndelegateGasLimit
+		}
+		return s.RedeemGasLimit
+	}
+	return 0
+}
+
+// gasLimit returns the gas limit of the PPOS transaction of fnType from the
+// address with the encoded data: the configured one of the task, else the
+// gas of PPOSGas. With EstimateGas, and always for redeem, the node
+// estimates it too, the higher of both is used.
+func (s *Service) gasLimit(ctx context.Context, addr *Addr, fnType int64, data []byte) (uint64, error) {
+	if gas := s.gasOverride(fnType); gas > 0 {
+		return gas, nil
+	}
+	var nodes, epochs uint64
+	if fnType == rewardCode {
+		var err error
+		if nodes, epochs, err = s.rewardEpochs(ctx, addr); err != nil {
+			return 0, fmt.Errorf("count reward epochs: %s", err)
+		}
+	}
+	gas, err := PPOSGas(fnType, data, nodes, epochs)
+	if err != nil {
+		return 0, err
+	}
+	if !s.EstimateGas && fnType != redeemCode {
+		return gas, nil
+	}
+
+	to, err := utils.ConvertAndEncode(s.Arp, common.HexToAddress(utils.ContractAddr(fnType)).Bytes())
+	if err != nil {
+		return 0, err
+	}
+	estimated, err := s.client.EstimateGas(ctx, client.CallMsg{From: addr.ArpStr, To: to, Data: data})
+	if err != nil {
+		klog.Warningf("[gasLimit] current address: %s, function %d, estimate gas error: %s, use %d", addr.ArpStr, fnType, err, gas)
+		return gas, nil
+	}
+	if estimated != gas {
+		klog.Warningf("[gasLimit] current address: %s, function %d, computed gas %d, node estimated %d", addr.ArpStr, fnType, gas, estimated)
+	}
+	if estimated > gas {
+		gas = estimated
+	}
+	return gas, nil
+}
+
+// rewardEpochs returns the delegated nodes of the address and the epochs
+// since each delegation was last settled, summed, which the reward claim
+// pays gas for.
+func (s *Service) rewardEpochs(ctx context.Context, addr *Addr) (nodes, epochs uint64, err error) {
+	related, err := s.GetRelatedListByDelAddr(ctx, addr.Address)
+	var pposErr *PPOSError
+	if errors.As(err, &pposErr) {
+		// no delegation
+		return 0, 0, nil
+	}
+	if err != nil {
+		return
+	}
+	epoch, _, err := s.Epoch(ctx)
+	if err != nil {
+		return
+	}
+	for _, r := range related {
+		var id discv5.NodeID
+		if id, err = discv5.HexID(r.NodeID); err != nil {
+			return
+		}
+		delegation, derr := s.GetDelegateInfo(ctx, r.StakingBlockNum, addr.Address, id)
+		if derr != nil {
+			return 0, 0, derr
+		}
+		nodes++
+		if e := uint64(delegation.DelegateEpoch); uint64(epoch) > e {
+			epochs += uint64(epoch) - e
+		}
+	}
+	return
+}
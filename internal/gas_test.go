@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"gitee.com/zonzpoo/platonjob/client"
+	"gitee.com/zonzpoo/platonjob/conf"
+)
+
+func TestPPOSGas(t *testing.T) {
+	data := []byte{0x01, 0x00, 0x02} // 21000 + 68 + 4 + 68
+	tests := []struct {
+		fnType        int64
+		nodes, epochs uint64
+		want          uint64
+	}{
+		{fnType: delegateCode, want: 21140 + 6000 + 16000},
+		{fnType: withdrawDelegateCode, want: 21140 + 6000 + 8000},
+		{fnType: redeemCode, want: 21140 + 6000 + 8000},
+		{fnType: rewardCode, nodes: 2, epochs: 5, want: 21140 + 8000 + 2*1000 + 5*100},
+	}
+	for _, tt := range tests {
+		got, err := PPOSGas(tt.fnType, data, tt.nodes, tt.epochs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("PPOSGas(%d) = %d, want %d", tt.fnType, got, tt.want)
+		}
+	}
+	if _, err := PPOSGas(1000, data, 0, 0); err == nil {
+		t.Errorf("PPOSGas(1000): got no error")
+	}
+}
+
+func TestRedeemGasLimit(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := NewKeyAddr(key, nil, "lat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := redeemBufData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	computed, _ := PPOSGas(redeemCode, data, 0, 0)
+	api := &testNodeAPI{}
+	server := rpc.NewServer()
+	if err = server.RegisterName("platon", api); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	s := &Service{Config: &conf.Config{Arp: "lat"}, client: client.NewClient(rpc.DialInProc(server))}
+
+	// checked with the node without EstimateGas
+	tests := []struct {
+		estimate, want uint64
+	}{
+		{estimate: computed - 1000, want: computed},
+		{estimate: computed + 1000, want: computed + 1000},
+	}
+	for _, tt := range tests {
+		api.estimate = tt.estimate
+		got, err := s.gasLimit(context.Background(), addr, redeemCode, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("estimate %d: gasLimit = %d, want %d", tt.estimate, got, tt.want)
+		}
+	}
+}
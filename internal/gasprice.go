@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"k8s.io/klog"
+
+	"gitee.com/zonzpoo/platonjob/conf"
+	"gitee.com/zonzpoo/platonjob/utils"
+)
+
+const (
+	defaultPercentile       = 60
+	defaultPercentileBlocks = 20
+)
+
+// TxGasPrice returns the gas price of the next transactions by the GasPrice
+// mode, capped at its MaxPrice. It is 0 in async mode.
+func (s *Service) TxGasPrice(ctx context.Context) (*big.Int, error) {
+	if s.IsAsync() {
+		return big.NewInt(0), nil
+	}
+	cfg := s.Config.GasPrice
+	var (
+		price *big.Int
+		err   error
+	)
+	switch cfg.Mode {
+	case conf.GasPriceFixed:
+		price = gvonToVon(cfg.Price)
+	case conf.GasPricePercentile:
+		price, err = s.percentileGasPrice(ctx)
+	default:
+		if price, err = s.gasPrice(ctx); err == nil && cfg.Multiplier > 0 {
+			price, _ = new(big.Float).Mul(new(big.Float).SetInt(price), big.NewFloat(cfg.Multiplier)).Int(nil)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if max := gvonToVon(cfg.MaxPrice); max.Sign() > 0 && price.Cmp(max) > 0 {
+		klog.Warningf("[TxGasPrice] %s gas price %s above the maximum, use %s", cfg.Mode, price, max)
+		price = max
+	}
+	return price, nil
+}
+
+func gvonToVon(price float64) *big.Int {
+	von, _ := new(big.Float).Mul(big.NewFloat(price), big.NewFloat(gvon)).Int(nil)
+	return von
+}
+
+// percentileGasPrice returns the Percentile of the gas prices paid in the
+// last Blocks blocks, read at most once per gasPriceTTL. Without any
+// transaction in them it is the node's price.
+func (s *Service) percentileGasPrice(ctx context.Context) (*big.Int, error) {
+	if price := s.percentiles.get(); price != nil {
+		return price, nil
+	}
+	percentile, blocks := s.Config.GasPrice.Percentile, s.Config.GasPrice.Blocks
+	if percentile <= 0 {
+		percentile = defaultPercentile
+	}
+	if blocks <= 0 {
+		blocks = defaultPercentileBlocks
+	}
+	prices, err := s.client.RecentGasPrices(ctx, blocks)
+	if err != nil {
+		return nil, err
+	}
+	price := Percentile(prices, percentile)
+	if price == nil {
+		return s.gasPrice(ctx)
+	}
+	s.percentiles.set(price)
+	return price, nil
+}
+
+// Percentile returns the price that p percent of the prices are at most,
+// nil without prices.
+func Percentile(prices []*big.Int, p int) *big.Int {
+	if len(prices) == 0 {
+		return nil
+	}
+	sorted := append([]*big.Int{}, prices...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Cmp(sorted[j]) < 0
+	})
+	if p > 100 {
+		p = 100
+	}
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return new(big.Int).Set(sorted[i])
+}
+
+// feeBudget is what the transactions sent in an epoch may cost at most.
+type feeBudget struct {
+	lock  *sync.Mutex
+	epoch int64
+	spent *big.Int
+}
+
+func newFeeBudget() *feeBudget {
+	return &feeBudget{lock: &sync.Mutex{}, spent: big.NewInt(0)}
+}
+
+// reserve adds fee to the fees of the epoch unless they would exceed budget,
+// and returns the fees of the epoch.
+func (b *feeBudget) reserve(epoch int64, fee, budget *big.Int) (*big.Int, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if epoch != b.epoch {
+		b.epoch, b.spent = epoch, big.NewInt(0)
+	}
+	spent := new(big.Int).Add(b.spent, fee)
+	if spent.Cmp(budget) > 0 {
+		return new(big.Int).Set(b.spent), false
+	}
+	b.spent = spent
+	return new(big.Int).Set(spent), true
+}
+
+// release gives back a fee reserved in the epoch.
+func (b *feeBudget) release(epoch int64, fee *big.Int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if epoch != b.epoch {
+		return
+	}
+	b.spent.Sub(b.spent, fee)
+	if b.spent.Sign() < 0 {
+		b.spent.SetInt64(0)
+	}
+}
+
+// feeReservation is a fee reserved in the budget of an epoch.
+type feeReservation struct {
+	epoch int64
+	fee   *big.Int
+}
+
+// releaseFeeOnError gives the reserved fee back when the transaction was not
+// sent, so a retry or a later action can spend it. r may be nil.
+func (s *Service) releaseFeeOnError(r *feeReservation, err *error) {
+	if r != nil && *err != nil {
+		s.fees.release(r.epoch, r.fee)
+	}
+}
+
+// reserveFee checks the fee of a transaction with the gas limit and price
+// before it is signed: it skips an action moving less than MinValueRatio times
+// the fee, value nil is not checked, and defers one the FeeBudget of the epoch
+// cannot pay for. The fee is reserved at the gas limit, a transaction using
+// less is not refunded, one that was not sent gives it back with
+// releaseFeeOnError. The fees are counted since the job started.
+func (s *Service) reserveFee(ctx context.Context, addr *Addr, gasLimit uint64, gasPrice, value *big.Int) (*feeReservation, error) {
+	fee := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gasLimit))
+	if fee.Sign() == 0 {
+		return nil, nil
+	}
+	cfg := s.Config.GasPrice
+	if value != nil && cfg.MinValueRatio > 0 {
+		min, _ := new(big.Float).Mul(new(big.Float).SetInt(fee), big.NewFloat(cfg.MinValueRatio)).Int(nil)
+		if value.Cmp(min) < 0 {
+			return nil, skipf("[reserveFee] current address: %s, value %s below %v times the fee %s",
+				addr.ArpStr, utils.HumReadBalance(value), cfg.MinValueRatio, utils.HumReadBalance(fee))
+		}
+	}
+	return s.reserveBudget(ctx, addr, fee)
+}
+
+// reserveBudget reserves fee in the FeeBudget of the epoch, nil without a
+// budget.
+func (s *Service) reserveBudget(ctx context.Context, addr *Addr, fee *big.Int) (*feeReservation, error) {
+	if s.Config.GasPrice.FeeBudget <= 0 || fee.Sign() <= 0 {
+		return nil, nil
+	}
+	epoch, _, err := s.Epoch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get epoch error: %s", err)
+	}
+	spent, ok := s.fees.reserve(epoch, fee, utils.ToVon(s.Config.GasPrice.FeeBudget))
+	if !ok {
+		return nil, skipf("[reserveFee] current address: %s, fee %s over the budget of epoch %d, spent %s, deferred to the next epoch",
+			addr.ArpStr, utils.HumReadBalance(fee), epoch, utils.HumReadBalance(spent))
+	}
+	return &feeReservation{epoch: epoch, fee: fee}, nil
+}
+
+// MaxReplacePrice returns the gas price a replacement never exceeds, the
+// lower of MaxGasPrice and the MaxPrice of the gas price strategy.
+func (s *Service) MaxReplacePrice() *big.Int {
+	max := s.MaxGasPrice()
+	if ceiling := gvonToVon(s.Config.GasPrice.MaxPrice); ceiling.Sign() > 0 && ceiling.Cmp(max) < 0 {
+		max = ceiling
+	}
+	return max
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	var prices []*big.Int
+	for _, p := range []int64{5, 1, 4, 2, 3, 6, 8, 7, 10, 9} {
+		prices = append(prices, big.NewInt(p))
+	}
+	tests := []struct {
+		p    int
+		want int64
+	}{
+		{p: 0, want: 1},
+		{p: 10, want: 1},
+		{p: 55, want: 6},
+		{p: 60, want: 6},
+		{p: 100, want: 10},
+		{p: 150, want: 10},
+	}
+	for _, tt := range tests {
+		if got := Percentile(prices, tt.p); got.Int64() != tt.want {
+			t.Errorf("Percentile(%d) = %s, want %d", tt.p, got, tt.want)
+		}
+	}
+	if got := Percentile(nil, 60); got != nil {
+		t.Errorf("Percentile(nil) = %s, want nil", got)
+	}
+}
+
+func TestFeeBudget(t *testing.T) {
+	b := newFeeBudget()
+	budget := big.NewInt(100)
+	if spent, ok := b.reserve(1, big.NewInt(60), budget); !ok || spent.Int64() != 60 {
+		t.Errorf("first fee: got %s, %v", spent, ok)
+	}
+	if spent, ok := b.reserve(1, big.NewInt(50), budget); ok || spent.Int64() != 60 {
+		t.Errorf("fee over the budget: got %s, %v", spent, ok)
+	}
+	if spent, ok := b.reserve(1, big.NewInt(40), budget); !ok || spent.Int64() != 100 {
+		t.Errorf("fee up to the budget: got %s, %v", spent, ok)
+	}
+	// a fee given back is spent again
+	b.release(1, big.NewInt(40))
+	if spent, ok := b.reserve(1, big.NewInt(30), budget); !ok || spent.Int64() != 90 {
+		t.Errorf("fee after release: got %s, %v", spent, ok)
+	}
+	// a new epoch starts with the whole budget
+	if spent, ok := b.reserve(2, big.NewInt(50), budget); !ok || spent.Int64() != 50 {
+		t.Errorf("next epoch: got %s, %v", spent, ok)
+	}
+}
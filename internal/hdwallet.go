@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tyler-smith/go-bip39"
+
+	"gitee.com/zonzpoo/platonjob/conf"
+)
+
+// DefaultHDPath is the BIP44 path template of PlatON, coin type 486.
+const DefaultHDPath = "m/44'/486'/0'/0/%d"
+
+const hardenedKeyStart = uint32(0x80000000)
+
+// HDAddr is an address derived from a wallet.
+type HDAddr struct {
+	Index uint32
+	Path  string
+	Key   *ecdsa.PrivateKey
+}
+
+// DeriveWallet derives the Count keys of the wallet from index Start.
+func DeriveWallet(wallet conf.Wallet) ([]*HDAddr, error) {
+	mnemonic := wallet.Mnemonic
+	if mnemonic == "" {
+		var err error
+		mnemonic, err = Passphrase(wallet.MnemonicFile, wallet.MnemonicEnv, fmt.Sprintf("Mnemonic of %s: ", wallet.Name))
+		if err != nil {
+			return nil, err
+		}
+	}
+	seed, err := bip39.NewSeedWithErrorChecking(strings.Join(strings.Fields(mnemonic), " "), "")
+	if err != nil {
+		return nil, err
+	}
+	template := wallet.Path
+	if template == "" {
+		template = DefaultHDPath
+	}
+	if !strings.Contains(template, "%d") {
+		return nil, fmt.Errorf("path %s has no %%d for the index", template)
+	}
+
+	addrs := make([]*HDAddr, 0, wallet.Count)
+	for index := wallet.Start; index < wallet.Start+wallet.Count; index++ {
+		path := fmt.Sprintf(template, index)
+		indexes, err := parseHDPath(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := deriveKey(seed, indexes)
+		if err != nil {
+			return nil, fmt.Errorf("path %s: %s", path, err)
+		}
+		addrs = append(addrs, &HDAddr{Index: index, Path: path, Key: key})
+	}
+	return addrs, nil
+}
+
+// parseHDPath parses a path like m/44'/486'/0'/0/1 into child indexes.
+func parseHDPath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if len(parts) < 2 || parts[0] != "m" {
+		return nil, fmt.Errorf("invalid path %s", path)
+	}
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'")
+		n, err := strconv.ParseUint(strings.TrimSuffix(part, "'"), 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %s: %s", path, err)
+		}
+		index := uint32(n)
+		if hardened {
+			index += hardenedKeyStart
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// deriveKey derives the BIP32 private key of the child indexes from seed.
+func deriveKey(seed []byte, indexes []uint32) (*ecdsa.PrivateKey, error) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	key, chainCode := sum[:32], sum[32:]
+	if _, err := crypto.ToECDSA(key); err != nil {
+		return nil, err
+	}
+
+	n := crypto.S256().Params().N
+	for _, index := range indexes {
+		// hardened: 0x00 || ser256(k) || ser32(i), else serP(K) || ser32(i)
+		data := make([]byte, 0, 37)
+		if index >= hardenedKeyStart {
+			data = append(append(data, 0), key...)
+		} else {
+			priv, err := crypto.ToECDSA(key)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, crypto.CompressPubkey(&priv.PublicKey)...)
+		}
+		data = append(data, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(data[len(data)-4:], index)
+
+		mac := hmac.New(sha512.New, chainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+		il := new(big.Int).SetBytes(sum[:32])
+		if il.Cmp(n) >= 0 {
+			return nil, fmt.Errorf("invalid child %d", index)
+		}
+		child := il.Add(il, new(big.Int).SetBytes(key))
+		child.Mod(child, n)
+		if child.Sign() == 0 {
+			return nil, fmt.Errorf("invalid child %d", index)
+		}
+		key, chainCode = math.PaddedBigBytes(child, 32), sum[32:]
+	}
+	return crypto.ToECDSA(key)
+}
@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"gitee.com/zonzpoo/platonjob/conf"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func TestDeriveWallet(t *testing.T) {
+	// the well known addresses of the test mnemonic on the ethereum path
+	want := []string{
+		"0x9858EfFD232B4033E47d90003D41EC34EcaEda94",
+		"0x6Fac4D18c912343BF86fa7049364Dd4E424Ab9C0",
+	}
+	addrs, err := DeriveWallet(conf.Wallet{Name: "test", Mnemonic: testMnemonic, Path: "m/44'/60'/0'/0/%d", Count: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != len(want) {
+		t.Fatalf("got %d addresses, want %d", len(addrs), len(want))
+	}
+	for i, addr := range addrs {
+		if got := crypto.PubkeyToAddress(addr.Key.PublicKey).Hex(); got != want[i] {
+			t.Errorf("index %d: got %s, want %s", addr.Index, got, want[i])
+		}
+	}
+
+	if _, err = DeriveWallet(conf.Wallet{Name: "bad", Mnemonic: "abandon abandon", Count: 1}); err == nil {
+		t.Error("accepted an invalid mnemonic")
+	}
+	if _, err = DeriveWallet(conf.Wallet{Name: "bad", Mnemonic: testMnemonic, Path: "m/44'/486'/0'/0/0", Count: 1}); err == nil {
+		t.Error("accepted a path without index")
+	}
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"gitee.com/zonzpoo/platonjob/conf"
+)
+
+// loadKey returns the private key of the address, from its keystore when one
+// is configured, else from the plaintext privateKey.
+func loadKey(ac *conf.Config, address conf.Addr) (*ecdsa.PrivateKey, error) {
+	switch {
+	case address.Keystore != "" && address.PrivateKey != "":
+		return nil, fmt.Errorf("address %s: both keystore and privateKey are set", address.Name)
+	case address.Keystore == "":
+		return crypto.HexToECDSA(address.PrivateKey)
+	}
+	data, err := ioutil.ReadFile(address.Keystore)
+	if err != nil {
+		return nil, err
+	}
+	file, env := address.PasswordFile, address.PasswordEnv
+	if file == "" && env == "" {
+		file, env = ac.PasswordFile, ac.PasswordEnv
+	}
+	passphrase, err := Passphrase(file, env, fmt.Sprintf("Passphrase of %s (%s): ", address.Name, address.Keystore))
+	if err != nil {
+		return nil, err
+	}
+	key, err := keystore.DecryptKey(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("keystore %s: %s", address.Keystore, err)
+	}
+	return key.PrivateKey, nil
+}
+
+// Passphrase reads a keystore passphrase from file, else from the environment
+// variable env, else from the terminal after printing prompt.
+func Passphrase(file, env, prompt string) (string, error) {
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if env != "" {
+		passphrase, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", env)
+		}
+		return passphrase, nil
+	}
+	return ReadSecret(prompt)
+}
+
+// ReadSecret prints prompt and reads a line from the terminal without echo.
+func ReadSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", fmt.Errorf("no terminal to read the passphrase from, set passwordFile or passwordEnv")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// ImportKey encrypts the hex private key with passphrase into a new keystore
+// file in dir.
+func ImportKey(dir, privateKey, passphrase string) (accounts.Account, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(privateKey, "0x"))
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	ks := keystore.NewKeyStore(dir, keystore.StandardScryptN, keystore.StandardScryptP)
+	return ks.ImportECDSA(key, passphrase)
+}
+
+// ListKeys returns the accounts of the keystore files in dir.
+func ListKeys(dir string) []accounts.Account {
+	ks := keystore.NewKeyStore(dir, keystore.StandardScryptN, keystore.StandardScryptP)
+	return ks.Accounts()
+}
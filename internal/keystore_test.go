@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pborman/uuid"
+
+	"gitee.com/zonzpoo/platonjob/conf"
+)
+
+func TestLoadKeystore(t *testing.T) {
+	dir := t.TempDir()
+	priv, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &keystore.Key{Id: uuid.NewRandom(), Address: crypto.PubkeyToAddress(priv.PublicKey), PrivateKey: priv}
+	data, err := keystore.EncryptKey(key, "secret", keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "key.json")
+	if err = ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	passwordFile := filepath.Join(dir, "password")
+	if err = ioutil.WriteFile(passwordFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PLATONJOB_TEST_PASSWORD", "secret")
+	ac := &conf.Config{PasswordEnv: "PLATONJOB_TEST_PASSWORD"}
+	for _, address := range []conf.Addr{
+		{Name: "file", Keystore: path, PasswordFile: passwordFile},
+		{Name: "default env", Keystore: path},
+	} {
+		got, err := loadKey(ac, address)
+		if err != nil {
+			t.Fatalf("%s: %s", address.Name, err)
+		}
+		if crypto.PubkeyToAddress(got.PublicKey) != key.Address {
+			t.Errorf("%s: got address %s, want %s", address.Name, crypto.PubkeyToAddress(got.PublicKey).Hex(), key.Address.Hex())
+		}
+	}
+
+	t.Setenv("PLATONJOB_TEST_PASSWORD", "wrong")
+	if _, err = loadKey(ac, conf.Addr{Name: "wrong", Keystore: path}); err == nil {
+		t.Error("decrypted with a wrong passphrase")
+	}
+	if _, err = loadKey(ac, conf.Addr{Name: "both", Keystore: path, PrivateKey: "00"}); err == nil {
+		t.Error("accepted both keystore and privateKey")
+	}
+}
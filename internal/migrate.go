@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p/discv5"
+	"k8s.io/klog"
+
+	"gitee.com/zonzpoo/platonjob/utils"
+	"gitee.com/zonzpoo/platonjob/utils/types"
+)
+
+// NodeHealth is the candidate list by node, read once per task run.
+type NodeHealth map[discv5.NodeID]*types.Candidate
+
+// NodeHealth reads the candidate list.
+func (s *Service) NodeHealth(ctx context.Context) (NodeHealth, error) {
+	candidates, err := s.GetCandidateList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	health := make(NodeHealth, len(candidates))
+	for _, c := range candidates {
+		id, err := discv5.HexID(c.NodeID)
+		if err != nil {
+			return nil, err
+		}
+		health[id] = c
+	}
+	return health, nil
+}
+
+// Check returns why the node is unhealthy, "" when it is healthy.
+func (h NodeHealth) Check(id discv5.NodeID) string {
+	c, ok := h[id]
+	if !ok {
+		return "not a candidate"
+	}
+	if c.Status != 0 {
+		return "status: " + statusString(c.Status)
+	}
+	return ""
+}
+
+// Healthy returns the nodes that pass Check, logging the others.
+func (h NodeHealth) Healthy(nodes []*Node) []*Node {
+	if h == nil {
+		return nodes
+	}
+	healthy := make([]*Node, 0, len(nodes))
+	for _, n := range nodes {
+		if reason := h.Check(n.ID); reason != "" {
+			klog.Warningf("[NodeHealth] node %s is unhealthy: %s", n.ID.TerminalString(), reason)
+			continue
+		}
+		healthy = append(healthy, n)
+	}
+	return healthy
+}
+
+// Migrate withdraws the delegations of the address to unhealthy target nodes.
+type Migrate struct {
+	*worker
+
+	health    NodeHealth
+	healthErr error
+	freeze    uint64 // epochs a withdrawn delegation stays locked
+	fallback  []*Node
+}
+
+// targets returns the configured and fallback nodes of the address, nil for nodeId auto.
+func (m *Migrate) targets(addr *Addr) map[discv5.NodeID]bool {
+	if addr.Nodes == nil {
+		return nil
+	}
+	targets := make(map[discv5.NodeID]bool, len(addr.Nodes)+len(m.fallback))
+	for _, n := range append(append([]*Node{}, addr.Nodes...), m.fallback...) {
+		targets[n.ID] = true
+	}
+	return targets
+}
+
+func (m *Migrate) sendTransactions(addr *Addr) (txs []*tp.Transaction, err error) {
+	if m.healthErr != nil {
+		err = fmt.Errorf("[Migrate sendTransactions] current address: %s, get candidate list error: %s", addr.ArpStr, m.healthErr)
+		return
+	}
+	related, err := m.GetRelatedListByDelAddr(m.ctx, addr.Address)
+	var pposErr *PPOSError
+	if errors.As(err, &pposErr) {
+		err = skipf("[Migrate sendTransactions] current address: %s has no delegation: %s", addr.ArpStr, err)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("[Migrate sendTransactions] current address: %s, get related list error: %s", addr.ArpStr, err)
+		return
+	}
+
+	targets := m.targets(addr)
+	for _, r := range related {
+		var nodeID discv5.NodeID
+		nodeID, err = discv5.HexID(r.NodeID)
+		if err != nil {
+			err = fmt.Errorf("[Migrate sendTransactions] current address: %s, invalid node id %s: %s", addr.ArpStr, r.NodeID, err)
+			return
+		}
+		if targets != nil && !targets[nodeID] {
+			continue
+		}
+		reason := m.health.Check(nodeID)
+		if reason == "" {
+			continue
+		}
+		var amount *big.Int
+		amount, err = m.DelegatedValue(m.ctx, r.StakingBlockNum, addr.Address, nodeID)
+		if err != nil {
+			err = fmt.Errorf("[Migrate sendTransactions] current address: %s, get delegate info error: %s", addr.ArpStr, err)
+			return
+		}
+		if amount.Sign() == 0 {
+			continue
+		}
+		var nonce uint64
+		if nonce, err = m.NextNonce(m.ctx, addr); err != nil {
+			err = fmt.Errorf("[Migrate sendTransactions] current address: %s, get nonce error: %s", addr.ArpStr, err)
+			return
+		}
+
+		var tx *tp.Transaction
+		tx, err = m.RunUndelegate(m.ctx, r.StakingBlockNum, nodeID, amount, addr, nonce)
+		if err != nil {
+			err = fmt.Errorf("[Migrate sendTransactions] current address %s run undelegate failed %w", addr.ArpStr, err)
+			return
+		}
+		klog.Warningf("[Migrate sendTransactions] current address: %s, node %s is unhealthy: %s, undelegate %s, nonce: %d, redelegate after %d epochs",
+			addr.ArpStr, nodeID.TerminalString(), reason, utils.HumReadBalance(amount), nonce, m.freeze)
+		txs = append(txs, tx)
+	}
+	if len(txs) == 0 {
+		err = skipf("[Migrate sendTransactions] current address: %s, every delegated target node is healthy", addr.ArpStr)
+	}
+	return
+}
+
+// MigrateDelegation migrates every address and waits for the receipts.
+func (s *Service) MigrateDelegation(ctx context.Context) *Result {
+	migrate := &Migrate{}
+	migrate.health, migrate.healthErr = s.NodeHealth(ctx)
+	if migrate.healthErr == nil {
+		migrate.fallback, migrate.healthErr = parseNodes("", s.Config.FallbackNodes)
+	}
+	if config, err := s.EconomicConfig(ctx); err == nil {
+		migrate.freeze = config.Staking.UnDelegateFreezeDuration
+	}
+	migrate.worker = newWorker(ctx, s, "Migrate", s.newAddrs(), migrate.sendTransactions)
+	return migrate.Start()
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p/discv5"
+
+	"gitee.com/zonzpoo/platonjob/utils/types"
+)
+
+// migrateSvc has delegations to the nodes by their first id byte and records
+// the undelegations sent.
+type migrateSvc struct {
+	SvcImpl
+	delegated map[byte]int64
+	fallback  []*Node
+	sent      []string
+}
+
+func (s *migrateSvc) GetRelatedListByDelAddr(ctx context.Context, address common.Address) (list []*types.DelegationRelated, err error) {
+	for id := byte(1); id < 10; id++ {
+		if _, ok := s.delegated[id]; ok {
+			list = append(list, &types.DelegationRelated{NodeID: discv5.NodeID{id}.String()})
+		}
+	}
+	return
+}
+
+func (s *migrateSvc) DelegatedValue(ctx context.Context, stakingBlockNum uint64, address common.Address, nodeID discv5.NodeID) (*big.Int, error) {
+	return big.NewInt(s.delegated[nodeID[0]]), nil
+}
+
+func (s *migrateSvc) NextNonce(ctx context.Context, addr *Addr) (uint64, error) {
+	return uint64(len(s.sent)), nil
+}
+
+func (s *migrateSvc) RunUndelegate(ctx context.Context, stakingBlockNum uint64, nodeID discv5.NodeID, amount *big.Int, addr *Addr, nonce uint64) (*tp.Transaction, error) {
+	s.sent = append(s.sent, fmt.Sprintf("%d:%s", nodeID[0], amount))
+	return tp.NewTransaction(nonce, common.Address{}, big.NewInt(0), 0, nil, nil), nil
+}
+
+func (s *migrateSvc) FallbackNodes(ctx context.Context) ([]*Node, error) {
+	return s.fallback, nil
+}
+
+// health of the nodes by their first id byte, missing ones left the candidates
+func testHealth(status map[byte]uint32) NodeHealth {
+	health := make(NodeHealth)
+	for id, st := range status {
+		health[discv5.NodeID{id}] = &types.Candidate{NodeID: discv5.NodeID{id}.String(), Status: st}
+	}
+	return health
+}
+
+func TestNodeHealth(t *testing.T) {
+	health := testHealth(map[byte]uint32{1: 0, 2: 32})
+	tests := []struct {
+		id   byte
+		want string
+	}{
+		{id: 1, want: ""},
+		{id: 2, want: "status: " + statusString(32)},
+		{id: 3, want: "not a candidate"},
+	}
+	for _, tt := range tests {
+		if got := health.Check(discv5.NodeID{tt.id}); got != tt.want {
+			t.Errorf("node %d: got %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	// 1 exits, 2 is healthy, 3 left the candidates but was delegated to by hand
+	health := testHealth(map[byte]uint32{1: 32, 2: 0})
+	tests := []struct {
+		name  string
+		nodes []*Node
+		want  []string
+		skip  bool
+	}{
+		{name: "configured nodes", nodes: []*Node{{ID: discv5.NodeID{1}}, {ID: discv5.NodeID{2}}}, want: []string{"1:100"}},
+		{name: "healthy nodes", nodes: []*Node{{ID: discv5.NodeID{2}}}, skip: true},
+		// the strategy may have selected any of them
+		{name: "auto", want: []string{"1:100", "3:300"}},
+	}
+	for _, tt := range tests {
+		svc := &migrateSvc{delegated: map[byte]int64{1: 100, 2: 200, 3: 300}}
+		m := &Migrate{worker: &worker{SvcImpl: svc, ctx: context.Background()}, health: health}
+		txs, err := m.sendTransactions(&Addr{ArpStr: "lat1", Nodes: tt.nodes})
+		_, skipped := err.(*skipError)
+		switch {
+		case tt.skip && !skipped:
+			t.Errorf("%s: got %v, want skipped", tt.name, err)
+		case !tt.skip && err != nil:
+			t.Errorf("%s: got %v", tt.name, err)
+		case fmt.Sprint(svc.sent) != fmt.Sprint(tt.want) || len(txs) != len(tt.want):
+			t.Errorf("%s: undelegated %v, want %v", tt.name, svc.sent, tt.want)
+		}
+	}
+}
+
+func TestDelegateNodes(t *testing.T) {
+	health := testHealth(map[byte]uint32{1: 32, 2: 0, 3: 32})
+	addr := &Addr{ArpStr: "lat1", Nodes: []*Node{{ID: discv5.NodeID{1}}}}
+	tests := []struct {
+		name     string
+		fallback []*Node
+		want     byte // 0 for no eligible node
+	}{
+		{name: "healthy fallback", fallback: []*Node{{ID: discv5.NodeID{3}}, {ID: discv5.NodeID{2}}}, want: 2},
+		{name: "no eligible node", fallback: []*Node{{ID: discv5.NodeID{3}}}},
+	}
+	for _, tt := range tests {
+		d := &Delegate{worker: &worker{SvcImpl: &migrateSvc{fallback: tt.fallback}, ctx: context.Background()},
+			health: health, selected: newLazyNodes(), fallback: newLazyNodes()}
+		nodes, err := d.nodes(addr)
+		switch {
+		case tt.want == 0 && err == nil:
+			t.Errorf("%s: got %d nodes, want an error", tt.name, len(nodes))
+		case tt.want != 0 && (err != nil || len(nodes) != 1 || nodes[0].ID[0] != tt.want):
+			t.Errorf("%s: got %v, %v, want node %d", tt.name, nodes, err, tt.want)
+		}
+	}
+}
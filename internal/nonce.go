@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// a nonce not handed out for nonceTTL is read from the pending pool again, in
+// case a transaction was dropped or the address was used elsewhere
+const nonceTTL = time.Minute
+
+// nonceManager hands out sequential nonces per address, so the transactions
+// of several tasks and the chained transactions of one task never reuse a
+// nonce. The first nonce of an address comes from the pending pool.
+type nonceManager struct {
+	lock  *sync.Mutex
+	addrs map[string]*addrNonce
+}
+
+type addrNonce struct {
+	lock   *sync.Mutex
+	synced bool
+	next   uint64
+	used   time.Time
+}
+
+func newNonceManager() *nonceManager {
+	return &nonceManager{lock: &sync.Mutex{}, addrs: make(map[string]*addrNonce)}
+}
+
+func (m *nonceManager) get(arpStr string) *addrNonce {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	n, ok := m.addrs[arpStr]
+	if !ok {
+		n = &addrNonce{lock: &sync.Mutex{}}
+		m.addrs[arpStr] = n
+	}
+	return n
+}
+
+// NextNonce hands out the next nonce of the address.
+func (s *Service) NextNonce(ctx context.Context, addr *Addr) (uint64, error) {
+	n := s.nonces.get(addr.ArpStr)
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if !n.synced || time.Since(n.used) > nonceTTL {
+		pending, err := s.client.PendingNonceAt(ctx, addr.ArpStr)
+		if err != nil {
+			return 0, err
+		}
+		n.next, n.synced = pending, true
+	}
+	nonce := n.next
+	n.next++
+	n.used = time.Now()
+	return nonce, nil
+}
+
+// seed sets the next nonce of the address from a pending nonce read
+// elsewhere, unless the manager holds a fresher one.
+func (m *nonceManager) seed(arpStr string, pending uint64) {
+	n := m.get(arpStr)
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if !n.synced || time.Since(n.used) > nonceTTL {
+		n.next, n.synced, n.used = pending, true, time.Now()
+	}
+}
+
+// ResetNonce makes the next nonce of the address be read from the pending
+// pool again.
+func (s *Service) ResetNonce(addr *Addr) {
+	n := s.nonces.get(addr.ArpStr)
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.synced = false
+}
+
+// resetNonceOnError resets the nonce of the address when a transaction could
+// not be sent, its nonce was not used ("nonce too low" and the like).
+func (s *Service) resetNonceOnError(addr *Addr, err *error) {
+	if *err != nil {
+		klog.Warningf("[resetNonce] current address: %s, resync nonce after: %s", addr.ArpStr, *err)
+		s.ResetNonce(addr)
+	}
+}
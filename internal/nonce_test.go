@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"gitee.com/zonzpoo/platonjob/client"
+)
+
+// testPlatonAPI stands in for the platon namespace of a node.
+type testPlatonAPI struct {
+	pending uint64
+}
+
+func (api *testPlatonAPI) GetTransactionCount(account, block string) hexutil.Uint64 {
+	if block != "pending" {
+		return 0
+	}
+	return hexutil.Uint64(api.pending)
+}
+
+func TestNextNonce(t *testing.T) {
+	api := &testPlatonAPI{pending: 5}
+	server := rpc.NewServer()
+	if err := server.RegisterName("platon", api); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	s := &Service{client: client.NewClient(rpc.DialInProc(server)), nonces: newNonceManager()}
+
+	addr, other := &Addr{ArpStr: "lat1a"}, &Addr{ArpStr: "lat1b"}
+	next := func(addr *Addr, want uint64) {
+		t.Helper()
+		nonce, err := s.NextNonce(context.Background(), addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nonce != want {
+			t.Errorf("%s: got nonce %d, want %d", addr.ArpStr, nonce, want)
+		}
+	}
+	next(addr, 5)
+	next(addr, 6)
+	next(other, 5)
+
+	// the pool never saw nonce 6, resync from it
+	s.ResetNonce(addr)
+	api.pending = 6
+	next(addr, 6)
+	next(addr, 7)
+}
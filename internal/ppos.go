@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/p2p/discv5"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"gitee.com/zonzpoo/platonjob/client"
+)
+
+// PPOSError is a failure code returned by a built-in PPOS contract in the
+// receipt log. Two errors are equal when their codes are, so errors.Is works
+// against the sentinels below whatever the function type.
+type PPOSError struct {
+	FnType int64
+	Code   uint32
+	Msg    string
+}
+
+func (e *PPOSError) Error() string {
+	return fmt.Sprintf("ppos function %d failed with code %d: %s", e.FnType, e.Code, e.Msg)
+}
+
+// Is reports whether target is a PPOSError with the same code.
+func (e *PPOSError) Is(target error) bool {
+	t, ok := target.(*PPOSError)
+	return ok && t.Code == e.Code
+}
+
+// https://devdocs.platon.network/docs/zh-CN/Error_Code  staking (301xxx) and reward (305xxx) codes
+var (
+	ErrCandidateNotExist       = &PPOSError{Code: 301102, Msg: "candidate not exist"}
+	ErrCandidateInvalid        = &PPOSError{Code: 301103, Msg: "candidate status is invalid"}
+	ErrDelegateTooLow          = &PPOSError{Code: 301105, Msg: "delegation amount too low"}
+	ErrDelegateNotAllowed      = &PPOSError{Code: 301106, Msg: "account is not allowed to delegate"}
+	ErrDelegateRejected        = &PPOSError{Code: 301107, Msg: "candidate does not accept delegation"}
+	ErrWithdrawDelegateTooLow  = &PPOSError{Code: 301108, Msg: "withdraw delegation amount too low"}
+	ErrDelegationNotExist      = &PPOSError{Code: 301109, Msg: "delegation not exist"}
+	ErrWrongVonType            = &PPOSError{Code: 301110, Msg: "wrong von type"}
+	ErrBalanceNotEnough        = &PPOSError{Code: 301111, Msg: "balance not enough"}
+	ErrDelegationNotEnough     = &PPOSError{Code: 301113, Msg: "delegation not enough"}
+	ErrWithdrawDelegateCalc    = &PPOSError{Code: 301114, Msg: "withdraw delegation calculation is wrong"}
+	ErrValidatorNotExist       = &PPOSError{Code: 301115, Msg: "validator not exist"}
+	ErrWrongParams             = &PPOSError{Code: 301116, Msg: "wrong function params"}
+	ErrRewardDelegationMissing = &PPOSError{Code: 305001, Msg: "delegation info not found"}
+)
+
+var pposErrors = map[uint32]*PPOSError{}
+
+func init() {
+	for _, e := range []*PPOSError{
+		ErrCandidateNotExist,
+		ErrCandidateInvalid,
+		ErrDelegateTooLow,
+		ErrDelegateNotAllowed,
+		ErrDelegateRejected,
+		ErrWithdrawDelegateTooLow,
+		ErrDelegationNotExist,
+		ErrWrongVonType,
+		ErrBalanceNotEnough,
+		ErrDelegationNotEnough,
+		ErrWithdrawDelegateCalc,
+		ErrValidatorNotExist,
+		ErrWrongParams,
+		ErrRewardDelegationMissing,
+	} {
+		pposErrors[e.Code] = e
+	}
+}
+
+// pposError turns a result code into a typed error, nil for success.
+func pposError(fnType int64, code uint32) error {
+	if code == 0 {
+		return nil
+	}
+	msg := "unknown error"
+	if e, ok := pposErrors[code]; ok {
+		msg = e.Msg
+	}
+	return &PPOSError{FnType: fnType, Code: code, Msg: msg}
+}
+
+// DecodePPOSResult decodes the receipt logs of a transaction sent to a
+// built-in contract and returns the typed error it reported, if any.
+func DecodePPOSResult(data []byte, logs []*client.Log) error {
+	code, err := pposCode(logs)
+	if err != nil {
+		return err
+	}
+	return pposError(pposFnType(data), code)
+}
+
+// pposFnType returns the function type encoded as the first parameter of the
+// transaction data, 0 for data that is not a PPOS call.
+func pposFnType(data []byte) int64 {
+	var params [][]byte
+	if err := rlp.DecodeBytes(data, &params); err != nil || len(params) == 0 {
+		return 0
+	}
+	var fn uint16
+	if err := rlp.DecodeBytes(params[0], &fn); err != nil {
+		return 0
+	}
+	return int64(fn)
+}
+
+// pposCode returns the result code a built-in contract wrote into the receipt
+// logs, 0 means success. The log data is a rlp list whose first item is the
+// code as a decimal string.
+func pposCode(logs []*client.Log) (code uint32, err error) {
+	if len(logs) == 0 {
+		return
+	}
+	var items []rlp.RawValue
+	err = rlp.DecodeBytes(logs[0].Data, &items)
+	if err != nil {
+		return
+	}
+	if len(items) == 0 {
+		err = fmt.Errorf("empty ppos log data")
+		return
+	}
+	var codeBytes []byte
+	err = rlp.DecodeBytes(items[0], &codeBytes)
+	if err != nil {
+		return
+	}
+	c, err := strconv.ParseUint(string(codeBytes), 10, 32)
+	if err != nil {
+		return
+	}
+	code = uint32(c)
+	return
+}
+
+// claimedReward is an item of the reward list withdrawDelegateReward (5000)
+// writes into its receipt log.
+type claimedReward struct {
+	NodeID     discv5.NodeID
+	StakingNum uint64
+	Reward     *big.Int
+}
+
+// DecodeClaimedReward returns the total reward a successful 5000 claimed. The
+// log data is a rlp list of the code and the rlp encoded reward list.
+func DecodeClaimedReward(logs []*client.Log) (total *big.Int, err error) {
+	total = big.NewInt(0)
+	if len(logs) == 0 {
+		err = fmt.Errorf("no reward log")
+		return
+	}
+	var items [][]byte
+	if err = rlp.DecodeBytes(logs[0].Data, &items); err != nil {
+		return
+	}
+	if len(items) < 2 {
+		err = fmt.Errorf("no reward list in log")
+		return
+	}
+	var rewards []*claimedReward
+	if err = rlp.DecodeBytes(items[1], &rewards); err != nil {
+		return
+	}
+	for _, r := range rewards {
+		total.Add(total, r.Reward)
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/discv5"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"gitee.com/zonzpoo/platonjob/client"
+)
+
+func pposLog(t *testing.T, code string) *client.Log {
+	data, err := rlp.EncodeToBytes([][]byte{[]byte(code)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &client.Log{Data: data}
+}
+
+func TestDecodePPOSResult(t *testing.T) {
+	buf, err := rewardBufData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DecodePPOSResult(buf, []*client.Log{pposLog(t, "0")}); err != nil {
+		t.Errorf("code 0: got %v, want nil", err)
+	}
+	if err := DecodePPOSResult(buf, nil); err != nil {
+		t.Errorf("no logs: got %v, want nil", err)
+	}
+
+	err = DecodePPOSResult(buf, []*client.Log{pposLog(t, "301111")})
+	if !errors.Is(err, ErrBalanceNotEnough) {
+		t.Fatalf("got %v, want %v", err, ErrBalanceNotEnough)
+	}
+	var pposErr *PPOSError
+	if !errors.As(err, &pposErr) || pposErr.FnType != rewardCode {
+		t.Errorf("got function type %d, want %d", pposErr.FnType, rewardCode)
+	}
+
+	err = DecodePPOSResult(buf, []*client.Log{pposLog(t, "399999")})
+	if !errors.As(err, &pposErr) || pposErr.Code != 399999 {
+		t.Errorf("unknown code: got %v", err)
+	}
+}
+
+func TestDecodeClaimedReward(t *testing.T) {
+	rewards, err := rlp.EncodeToBytes([]*claimedReward{
+		{NodeID: discv5.NodeID{1}, StakingNum: 10, Reward: big.NewInt(1500)},
+		{NodeID: discv5.NodeID{2}, StakingNum: 20, Reward: big.NewInt(2500)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := rlp.EncodeToBytes([][]byte{[]byte("0"), rewards})
+	if err != nil {
+		t.Fatal(err)
+	}
+	total, err := DecodeClaimedReward([]*client.Log{{Data: data}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.Int64() != 4000 {
+		t.Errorf("got %s, want 4000", total)
+	}
+
+	if _, err = DecodeClaimedReward([]*client.Log{pposLog(t, "0")}); err == nil {
+		t.Error("decoded a log without reward list")
+	}
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+
+	"gitee.com/zonzpoo/platonjob/client"
+)
+
+// a gas price read by a prefetch, or on its own, is used for gasPriceTTL
+const gasPriceTTL = 30 * time.Second
+
+// AddrState is the state of an address prefetched for a task run.
+type AddrState struct {
+	Balance *big.Int
+	Reward  *big.Int // nil unless the rewards were prefetched
+}
+
+type gasPriceCache struct {
+	lock  *sync.Mutex
+	price *big.Int
+	at    time.Time
+}
+
+func newGasPriceCache() *gasPriceCache {
+	return &gasPriceCache{lock: &sync.Mutex{}}
+}
+
+func (c *gasPriceCache) set(price *big.Int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.price, c.at = price, time.Now()
+}
+
+func (c *gasPriceCache) get() *big.Int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.price == nil || time.Since(c.at) > gasPriceTTL {
+		return nil
+	}
+	return new(big.Int).Set(c.price)
+}
+
+// Prefetch reads the balance and the pending nonce of every address, with
+// rewards its delegate reward too, and the gas price in a few batch requests
+// instead of several requests per address. The nonces seed the nonce manager
+// and the gas price is kept for gasPriceTTL. An address that could not be
+// read is left out, the task reads it on its own.
+func (s *Service) Prefetch(ctx context.Context, addrs []*Addr, rewards bool) (map[string]*AddrState, error) {
+	accounts := make([]string, len(addrs))
+	var calls []client.CallMsg
+	if rewards {
+		calls = make([]client.CallMsg, len(addrs))
+	}
+	for i, addr := range addrs {
+		accounts[i] = addr.ArpStr
+		if rewards {
+			msg, err := addr.RewardMsg(ctx, s.Arp)
+			if err != nil {
+				return nil, err
+			}
+			calls[i] = msg
+		}
+	}
+	states, gasPrice, err := s.client.BatchAccounts(ctx, accounts, calls)
+	if err != nil {
+		return nil, err
+	}
+	s.gasPrices.set(gasPrice)
+
+	prefetched := make(map[string]*AddrState, len(states))
+	for i, st := range states {
+		addr := addrs[i]
+		if st.Err != nil {
+			klog.Warningf("[Prefetch] current address: %s, read state error: %s", addr.ArpStr, st.Err)
+			continue
+		}
+		state := &AddrState{Balance: st.Balance}
+		if rewards {
+			if state.Reward, err = decodeRewards(st.Call); err != nil {
+				klog.Warningf("[Prefetch] current address: %s, decode reward error: %s", addr.ArpStr, err)
+				continue
+			}
+		}
+		s.nonces.seed(addr.ArpStr, st.Nonce)
+		prefetched[addr.ArpStr] = state
+	}
+	return prefetched, nil
+}
+
+// gasPrice returns the gas price of the node, read at most once per
+// gasPriceTTL.
+func (s *Service) gasPrice(ctx context.Context) (*big.Int, error) {
+	if price := s.gasPrices.get(); price != nil {
+		return price, nil
+	}
+	price, err := s.client.GasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.gasPrices.set(price)
+	return price, nil
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"k8s.io/klog"
+
+	"gitee.com/zonzpoo/platonjob/utils"
+)
+
+const redeemCode = int64(1006)
+
+// Redeem redeems the matured locked delegations of every address.
+type Redeem struct {
+	*worker
+}
+
+func (r *Redeem) sendTransaction(addr *Addr) (tx *tp.Transaction, err error) {
+	info, err := r.GetDelegationLockInfo(r.ctx, addr.Address)
+	if err != nil {
+		err = fmt.Errorf("[Redeem sendTransaction] current address: %s, get lock info error: %s", addr.ArpStr, err)
+		return
+	}
+	matured := big.NewInt(0).Add(toBig(info.Released), toBig(info.RestrictingPlan))
+	if matured.Sign() == 0 {
+		err = skipf("[Redeem sendTransaction] current address: %s has no matured lock, still locked: %d", addr.ArpStr, len(info.Locks))
+		return
+	}
+	nonce, err := r.NextNonce(r.ctx, addr)
+	if err != nil {
+		err = fmt.Errorf("[Redeem sendTransaction] current address: %s get nonce err: %s", addr.ArpStr, err)
+		return
+	}
+	tx, err = r.RunRedeem(r.ctx, addr, nonce)
+	if err != nil {
+		err = fmt.Errorf("[Redeem sendTransaction] current address %s redeem failed %w", addr.ArpStr, err)
+		return
+	}
+	klog.Infof("[Redeem sendTransaction] finished send redeem, current address: %s, matured: %s, nonce: %d", addr.ArpStr, utils.HumReadBalance(matured), nonce)
+	return
+}
+
+// RedeemDelegation redeems the matured locked delegations of every address and
+// waits for the receipts. The freed balance is picked up by the next InitDelegate.
+func (s *Service) RedeemDelegation(ctx context.Context) *Result {
+	redeem := &Redeem{}
+	redeem.worker = newWorker(ctx, s, "Redeem", s.newAddrs(), single(redeem.sendTransaction))
+	return redeem.Start()
+}
+
+// RunRedeem redeems every matured locked delegation of the address, released
+// funds return to the free balance and restricting funds to the lock-up plan.
+func (s *Service) RunRedeem(ctx context.Context, addr *Addr, nonce uint64) (tx *tp.Transaction, err error) {
+	defer s.resetNonceOnError(addr, &err)
+	var (
+		gasPrice *big.Int
+	)
+	address := utils.ContractAddr(redeemCode)
+	if address == "" {
+		err = fmt.Errorf("invalid contract code: %d", redeemCode)
+		return
+	}
+
+	buf, err := redeemBufData()
+	if err != nil {
+		return
+	}
+	gasPrice, err = s.TxGasPrice(ctx)
+	if err != nil {
+		return
+	}
+
+	gasLimit, err := s.gasLimit(ctx, addr, redeemCode, buf)
+	if err != nil {
+		return
+	}
+	fee, err := s.reserveFee(ctx, addr, gasLimit, gasPrice, nil)
+	if err != nil {
+		return
+	}
+	defer s.releaseFeeOnError(fee, &err)
+
+	tx, err = addr.SignTx(ctx,
+		tp.NewTransaction(
+			nonce,
+			common.HexToAddress(address),
+			big.NewInt(0),
+			gasLimit,
+			gasPrice,
+			buf))
+	if err != nil {
+		return
+	}
+	err = s.sendTx(ctx, addr, tx)
+	return
+}
+
+func redeemBufData() (buf []byte, err error) {
+	fnType, err := rlp.EncodeToBytes(uint16(redeemCode))
+	if err != nil {
+		return
+	}
+
+	params := make([][]byte, 0)
+	params = append(params, fnType)
+
+	byteBuf := new(bytes.Buffer)
+	err = rlp.Encode(byteBuf, params)
+	if err != nil {
+		return
+	}
+	buf = byteBuf.Bytes()
+	return
+}
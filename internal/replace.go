@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"k8s.io/klog"
+
+	"gitee.com/zonzpoo/platonjob/client"
+	"gitee.com/zonzpoo/platonjob/conf"
+	"gitee.com/zonzpoo/platonjob/utils"
+)
+
+const (
+	defaultStuckBlocks = int64(30)
+	// default gas price ceiling of a replacement, in gvon
+	defaultMaxGasPrice = 100
+	// the pool only accepts a replacement paying at least 10% more
+	priceBump = 10
+	gvon      = 1e9
+)
+
+// Replacement is a stuck transaction resent with the same nonce.
+type Replacement struct {
+	Old      common.Hash
+	New      common.Hash
+	GasPrice *big.Int
+	Cancel   bool  // a zero value transfer to itself
+	Block    int64 // the block the replacement was sent at
+
+	tx *tp.Transaction
+}
+
+// StuckBlocks returns the blocks a transaction may stay pending before it is
+// replaced, 0 when stuck transactions are never replaced.
+func (s *Service) StuckBlocks() int64 {
+	switch {
+	case s.Stuck.Blocks < 0:
+		return 0
+	case s.Stuck.Blocks == 0:
+		return defaultStuckBlocks
+	}
+	return s.Stuck.Blocks
+}
+
+// MaxGasPrice returns the Stuck.MaxGasPrice, in von, see MaxReplacePrice.
+func (s *Service) MaxGasPrice() *big.Int {
+	price := s.Stuck.MaxGasPrice
+	if price <= 0 {
+		price = defaultMaxGasPrice
+	}
+	return gvonToVon(price)
+}
+
+// WaitMined waits for the receipt of the transaction hash like WaitReceipt,
+// tx is what was sent with the hash, or rebuilt from it unsigned when it was
+// sent by an earlier run. When none of its transactions is mined within
+// StuckBlocks blocks it is replaced, or cancelled, with the same nonce and a
+// higher gas price, and the wait goes on for any of them. replaced is called
+// with every replacement sent. The receipt is the one of the transaction that
+// was mined.
+func (s *Service) WaitMined(ctx context.Context, addr *Addr, hash common.Hash, tx *tp.Transaction, replaced func(*Replacement)) (*client.Receipt, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.ReceiptTimeout())
+	defer cancel()
+
+	hashes := []common.Hash{hash}
+	var since int64 // the block the last transaction was first seen pending at
+	t := time.NewTicker(receiptInterval)
+	defer t.Stop()
+	for {
+		number, err := s.client.BlockNumberAt(ctx)
+		if err == nil {
+			if since == 0 {
+				since = number.Int64()
+			}
+			var receipt *client.Receipt
+			receipt, err = s.minedReceipt(ctx, hashes)
+			stuck := s.StuckBlocks()
+			switch {
+			case err != nil:
+			case receipt != nil:
+				if number.Uint64()+1 >= receipt.BlockNumber+s.confirmations() {
+					return receipt, nil
+				}
+			case stuck > 0 && number.Int64() >= since+stuck:
+				next, r, rerr := s.replaceTx(ctx, addr, hash, tx)
+				if rerr != nil {
+					klog.Warningf("[WaitMined] current address: %s, tx %s pending since block %d, replace error: %s", addr.ArpStr, hash.Hex(), since, rerr)
+				} else {
+					r.Block = number.Int64()
+					klog.Warningf("[WaitMined] current address: %s, tx %s pending since block %d, replaced by %s, gas price: %s, cancel: %v",
+						addr.ArpStr, r.Old.Hex(), since, r.New.Hex(), r.GasPrice, r.Cancel)
+					tx, hash = next, next.Hash()
+					hashes = append(hashes, hash)
+					if replaced != nil {
+						replaced(r)
+					}
+				}
+				// the replacement, or the next attempt, waits another stuck blocks
+				since = number.Int64()
+			}
+		}
+		if err != nil {
+			klog.Warningf("[WaitMined] tx %s, get receipt error: %s", hash.Hex(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("tx %s not confirmed: %w", hash.Hex(), ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// minedReceipt returns the receipt of whichever of the hashes was mined, nil
+// when none was. They share a nonce, so at most one is.
+func (s *Service) minedReceipt(ctx context.Context, hashes []common.Hash) (*client.Receipt, error) {
+	for _, hash := range hashes {
+		receipt, err := s.client.TransactionReceipt(ctx, hash)
+		if err == nil {
+			return receipt, nil
+		}
+		if !errors.Is(err, client.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// replaceTx signs and sends the replacement of the stuck tx sent with the
+// hash, only the fields of tx are used.
+func (s *Service) replaceTx(ctx context.Context, addr *Addr, hash common.Hash, tx *tp.Transaction) (*tp.Transaction, *Replacement, error) {
+	suggested, err := s.client.GasPrice(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	max := s.MaxReplacePrice()
+	price := bumpGasPrice(tx.GasPrice(), suggested, max)
+	if price == nil {
+		return nil, nil, fmt.Errorf("gas price %s already at the ceiling %s", tx.GasPrice(), max)
+	}
+
+	r := &Replacement{Old: hash, GasPrice: price, Cancel: s.Stuck.Action == conf.StuckCancel}
+	next := tp.NewTransaction(tx.Nonce(), *tx.To(), tx.Value(), tx.Gas(), price, tx.Data())
+	if r.Cancel {
+		next = tp.NewTransaction(tx.Nonce(), addr.Address, big.NewInt(0), transferGasLimit, price, nil)
+	}
+	// the fee budget pays what the replacement costs above the stuck tx
+	extra := new(big.Int).Mul(price, new(big.Int).SetUint64(next.Gas()))
+	extra.Sub(extra, new(big.Int).Mul(tx.GasPrice(), new(big.Int).SetUint64(tx.Gas())))
+	fee, err := s.reserveBudget(ctx, addr, extra)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer s.releaseFeeOnError(fee, &err)
+	if next, err = addr.SignTx(ctx, next); err != nil {
+		return nil, nil, err
+	}
+	if err = s.sendTx(ctx, addr, next); err != nil {
+		return nil, nil, err
+	}
+	r.New, r.tx = next.Hash(), next
+	return next, r, nil
+}
+
+// bumpGasPrice returns the gas price replacing a transaction paying old: at
+// least priceBump percent more and at least the suggested price, capped at
+// max. It returns nil when the cap leaves no room for the bump.
+func bumpGasPrice(old, suggested, max *big.Int) *big.Int {
+	min := new(big.Int).Mul(old, big.NewInt(100+priceBump))
+	min.Div(min, big.NewInt(100))
+	if min.Cmp(old) <= 0 {
+		min.Add(old, big.NewInt(1))
+	}
+	price := min
+	if suggested.Cmp(price) > 0 {
+		price = new(big.Int).Set(suggested)
+	}
+	if price.Cmp(max) > 0 {
+		price = new(big.Int).Set(max)
+	}
+	if price.Cmp(min) < 0 {
+		return nil
+	}
+	return price
+}
+
+// pendingTx rebuilds the transaction sent by an earlier run, so it can be
+// replaced while it is waited for. It is not signed, its hash is not the one
+// that was sent.
+func pendingTx(tx *client.Transaction) (*tp.Transaction, error) {
+	to, err := utils.DecodeAddress(tx.To)
+	if err != nil {
+		return nil, err
+	}
+	return tp.NewTransaction(tx.Nonce, to, tx.Value, tx.Gas, tx.GasPrice, tx.Input), nil
+}
+
+// minedTx returns which of tx and its replacements has the hash, and whether
+// it cancelled tx.
+func minedTx(tx *tp.Transaction, replaced []*Replacement, hash common.Hash) (*tp.Transaction, bool) {
+	for _, r := range replaced {
+		if r.New == hash {
+			return r.tx, r.Cancel
+		}
+	}
+	return tx, false
+}
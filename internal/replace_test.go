@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"gitee.com/zonzpoo/platonjob/client"
+	"gitee.com/zonzpoo/platonjob/conf"
+	"gitee.com/zonzpoo/platonjob/store"
+	"gitee.com/zonzpoo/platonjob/utils"
+)
+
+func TestBumpGasPrice(t *testing.T) {
+	tests := []struct {
+		old, suggested, max int64
+		want                int64 // 0 when it cannot be replaced
+	}{
+		{old: 100, suggested: 50, max: 1000, want: 110},
+		{old: 100, suggested: 500, max: 1000, want: 500},
+		{old: 100, suggested: 5000, max: 1000, want: 1000},
+		// a zero price async transaction pays at least the node's price
+		{old: 0, suggested: 0, max: 1000, want: 1},
+		{old: 0, suggested: 300, max: 1000, want: 300},
+		{old: 950, suggested: 0, max: 1000, want: 0},
+		{old: 1000, suggested: 2000, max: 1000, want: 0},
+	}
+	for _, tt := range tests {
+		got := bumpGasPrice(big.NewInt(tt.old), big.NewInt(tt.suggested), big.NewInt(tt.max))
+		switch {
+		case tt.want == 0 && got != nil:
+			t.Errorf("bumpGasPrice(%d, %d, %d) = %s, want nil", tt.old, tt.suggested, tt.max, got)
+		case tt.want != 0 && (got == nil || got.Int64() != tt.want):
+			t.Errorf("bumpGasPrice(%d, %d, %d) = %v, want %d", tt.old, tt.suggested, tt.max, got, tt.want)
+		}
+	}
+}
+
+// testNodeAPI is a node that mined the transaction with its hash.
+type testNodeAPI struct {
+	block    uint64
+	hash     common.Hash
+	tx       map[string]interface{}
+	sent     int // transactions sent to it
+	sendErr  error
+	estimate uint64
+}
+
+func (api *testNodeAPI) BlockNumber() hexutil.Uint64 {
+	api.block++
+	return hexutil.Uint64(api.block)
+}
+
+func (api *testNodeAPI) GetTransactionByHash(hash common.Hash) map[string]interface{} {
+	if hash != api.hash {
+		return nil
+	}
+	return api.tx
+}
+
+func (api *testNodeAPI) GetTransactionReceipt(hash common.Hash) map[string]interface{} {
+	if hash != api.hash {
+		return nil
+	}
+	return map[string]interface{}{"transactionHash": hash, "blockNumber": hexutil.Uint64(1), "status": hexutil.Uint64(1), "gasUsed": hexutil.Uint64(21000)}
+}
+
+func (api *testNodeAPI) SendRawTransaction(data hexutil.Bytes) error {
+	api.sent++
+	return api.sendErr
+}
+
+func (api *testNodeAPI) EstimateGas(args map[string]interface{}) hexutil.Uint64 {
+	return hexutil.Uint64(api.estimate)
+}
+
+func TestResumeMined(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := NewLocalSigner(key, big.NewInt(100))
+	addr, err := NewAddr(crypto.PubkeyToAddress(key.PublicKey), signer, "lat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := common.HexToAddress("0x1000000000000000000000000000000000000002")
+	tx, err := signer.SignTx(context.Background(), tp.NewTransaction(7, to, big.NewInt(0), 21000, big.NewInt(1e9), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	toStr, _ := utils.ConvertAndEncode("lat", to.Bytes())
+	api := &testNodeAPI{hash: tx.Hash(), sendErr: errors.New("nonce too low"), tx: map[string]interface{}{
+		"hash": tx.Hash(), "from": addr.ArpStr, "to": toStr, "nonce": hexutil.Uint64(7), "gas": hexutil.Uint64(21000),
+		"gasPrice": (*hexutil.Big)(big.NewInt(1e9)), "value": (*hexutil.Big)(big.NewInt(0)), "input": hexutil.Bytes{}, "blockNumber": (*hexutil.Big)(big.NewInt(1)),
+	}}
+	server := rpc.NewServer()
+	if err = server.RegisterName("platon", api); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	st, _ := store.Open("")
+	// replaced after a block unless its receipt is found
+	s := &Service{Config: &conf.Config{ReceiptTimeout: 3, Stuck: conf.Stuck{Blocks: 1}}, client: client.NewClient(rpc.DialInProc(server)),
+		store: st, econ: newEconomic(), nonces: newNonceManager(), gasPrices: newGasPriceCache(), percentiles: newGasPriceCache(), fees: newFeeBudget()}
+	w := newWorker(context.Background(), s, "Reward", []*Addr{addr}, nil)
+	w.put(&store.Record{Address: addr.ArpStr, Hash: tx.Hash().Hex(), Status: store.StatusSent})
+
+	receipts, _ := w.resume(addr)
+	if len(receipts) != 1 {
+		t.Fatalf("got %d receipts, want 1", len(receipts))
+	}
+	if r := receipts[0]; r.err != nil || r.hash != tx.Hash() || len(r.replaced) > 0 {
+		t.Errorf("got receipt %s, replaced %d, err %v, want %s", r.hash.Hex(), len(r.replaced), r.err, tx.Hash().Hex())
+	}
+	if api.sent > 0 {
+		t.Errorf("mined tx replaced %d times", api.sent)
+	}
+}
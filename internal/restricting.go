@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"gitee.com/zonzpoo/platonjob/utils/types"
+)
+
+const (
+	restrictingInfoCode = int64(4100)
+)
+
+// GetRestrictingInfo returns the lock-up plan of the address.
+func (s *Service) GetRestrictingInfo(ctx context.Context, address common.Address) (info *types.RestrictingInfo, err error) {
+	err = s.query(ctx, restrictingInfoCode, &info, address)
+	return
+}
+
+// GetRestrictingValue returns the part of the lock-up plan that is not pledged
+// yet and can be delegated with type 1.
+func (s *Service) GetRestrictingValue(ctx context.Context, address common.Address) (value *big.Int, err error) {
+	info, err := s.GetRestrictingInfo(ctx, address)
+	if err != nil {
+		return
+	}
+	value = big.NewInt(0).Sub(toBig(info.Balance), toBig(info.Pledge))
+	if value.Sign() < 0 {
+		value.SetInt64(0)
+	}
+	return
+}
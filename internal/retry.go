@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"k8s.io/klog"
+
+	"gitee.com/zonzpoo/platonjob/client"
+)
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryBackoff    = 5 * time.Second
+	defaultRetryMaxBackoff = time.Minute
+)
+
+// ErrorClass is how a failed request or transaction is handled.
+type ErrorClass int
+
+// error classes
+const (
+	// ClassUnknown is not retried
+	ClassUnknown ErrorClass = iota
+	// ClassTransient is a node that could not be reached or timed out
+	ClassTransient
+	// ClassNonce is a nonce already used or skipped, retried with a nonce
+	// read from the pending pool again
+	ClassNonce
+	// ClassInsufficient is a balance too low for the value and the gas
+	ClassInsufficient
+	// ClassKnown is a transaction the node already has, it counts as sent
+	ClassKnown
+	// ClassPPOS is a PPOS contract refusing the transaction, permanent
+	ClassPPOS
+	// ClassInvalid is a transaction the chain refuses for its parameters, or
+	// one mined as failed, permanent
+	ClassInvalid
+)
+
+var classNames = map[ErrorClass]string{
+	ClassUnknown:      "unknown",
+	ClassTransient:    "transient",
+	ClassNonce:        "nonce",
+	ClassInsufficient: "insufficient",
+	ClassKnown:        "known",
+	ClassPPOS:         "ppos",
+	ClassInvalid:      "invalid",
+}
+
+func (c ErrorClass) String() string {
+	return classNames[c]
+}
+
+// Retryable reports whether an address failing with the class is tried again.
+func (c ErrorClass) Retryable() bool {
+	return c == ClassTransient || c == ClassNonce
+}
+
+// Final reports whether an address failing with the class fails the same way
+// until the epoch ends, it is not run again in the epoch.
+func (c ErrorClass) Final() bool {
+	return c == ClassPPOS || c == ClassInsufficient || c == ClassInvalid
+}
+
+// ClassError is an error of a known class.
+type ClassError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (e *ClassError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ClassError) Unwrap() error {
+	return e.Err
+}
+
+// the messages of the node, and of the transport, by class. The errors are
+// mostly wrapped as text, so they are matched by message when they lost their
+// type.
+var classMessages = []struct {
+	class ErrorClass
+	msgs  []string
+}{
+	{ClassKnown, []string{"known transaction", "already known"}},
+	{ClassNonce, []string{"nonce too low", "nonce too high", "replacement transaction underpriced"}},
+	{ClassInsufficient, []string{"insufficient funds", "insufficient balance"}},
+	{ClassInvalid, []string{"intrinsic gas too low", "exceeds block gas limit", "gas limit reached", "oversized data", "negative value"}},
+	{ClassTransient, []string{"connection refused", "connection reset", "broken pipe", "no such host", "timeout", "deadline exceeded",
+		"eof", "no healthy endpoint", "502 bad gateway", "503 service unavailable", "504 gateway timeout", "429 too many requests"}},
+}
+
+// Classify returns the class of err.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ClassUnknown
+	}
+	var classErr *ClassError
+	if errors.As(err, &classErr) {
+		return classErr.Class
+	}
+	var pposErr *PPOSError
+	if errors.As(err, &pposErr) {
+		return ClassPPOS
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+		return ClassTransient
+	}
+	msg := strings.ToLower(err.Error())
+	for _, c := range classMessages {
+		for _, m := range c.msgs {
+			if strings.Contains(msg, m) {
+				return c.class
+			}
+		}
+	}
+	return ClassUnknown
+}
+
+// sendTx sends the signed transaction of the address, one the node already
+// knows counts as sent. The errors are classified. A transient error may come
+// after the node accepted the transaction, so it is looked up: found it counts
+// as sent, and when it cannot be looked up it is not retried, a retry would
+// send it again with the next nonce. The recorder of the context, if any,
+// records the transaction before it is broadcast.
+func (s *Service) sendTx(ctx context.Context, addr *Addr, tx *tp.Transaction) error {
+	rec, _ := ctx.Value(recorderKey{}).(txRecorder)
+	if rec != nil {
+		rec.sending(addr, tx)
+	}
+	err := s.client.SendTransaction(ctx, tx)
+	if err == nil {
+		return nil
+	}
+	class := Classify(err)
+	if class == ClassKnown {
+		klog.Infof("[sendTx] tx %s already known: %s", tx.Hash().Hex(), err)
+		return nil
+	}
+	if class == ClassTransient {
+		_, _, lerr := s.client.TransactionByHash(ctx, tx.Hash())
+		switch {
+		case lerr == nil:
+			klog.Warningf("[sendTx] tx %s accepted despite: %s", tx.Hash().Hex(), err)
+			return nil
+		case !errors.Is(lerr, client.ErrNotFound):
+			// left recorded as sent, a later run waits for it
+			return &ClassError{Class: ClassUnknown, Err: fmt.Errorf("%s, tx %s may have been sent, look up error: %s", err, tx.Hash().Hex(), lerr)}
+		}
+	}
+	if rec != nil {
+		rec.unsent(addr, tx, err)
+	}
+	return &ClassError{Class: class, Err: err}
+}
+
+// txRecorder records the signed transactions of an address before they are
+// broadcast, so a crash while sending still leaves their hashes to resume
+// from, see worker.
+type txRecorder interface {
+	sending(addr *Addr, tx *tp.Transaction)
+	// unsent is called when the node refused the transaction
+	unsent(addr *Addr, tx *tp.Transaction, err error)
+}
+
+type recorderKey struct{}
+
+// RetryPolicy is how often, and how long after, an address failing with a
+// retryable error is tried again in the same epoch.
+type RetryPolicy struct {
+	Attempts   int // in total, 1 never retries
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// RetryPolicy returns the retry policy of the config.
+func (s *Service) RetryPolicy() *RetryPolicy {
+	p := &RetryPolicy{
+		Attempts:   s.Retry.Attempts,
+		Backoff:    time.Duration(s.Retry.Backoff) * time.Second,
+		MaxBackoff: time.Duration(s.Retry.MaxBackoff) * time.Second,
+	}
+	switch {
+	case p.Attempts < 0:
+		p.Attempts = 1
+	case p.Attempts == 0:
+		p.Attempts = defaultRetryAttempts
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = defaultRetryBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultRetryMaxBackoff
+	}
+	return p
+}
+
+// Delay returns the wait before the attempt after the given one, doubling
+// from Backoff up to MaxBackoff.
+func (p *RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// Budget returns the longest time the retries of an address wait in total.
+func (p *RetryPolicy) Budget() (budget time.Duration) {
+	for attempt := 1; attempt < p.Attempts; attempt++ {
+		budget += p.Delay(attempt)
+	}
+	return
+}
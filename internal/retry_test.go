@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"gitee.com/zonzpoo/platonjob/client"
+	"gitee.com/zonzpoo/platonjob/conf"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorClass
+	}{
+		{errors.New("nonce too low"), ClassNonce},
+		{fmt.Errorf("[Reward sendTransaction] current address: lat1, get reward failed %s", errors.New("insufficient funds for gas * price + value")), ClassInsufficient},
+		{errors.New("known transaction: 0x01"), ClassKnown},
+		{errors.New(`Post "http://127.0.0.1:6789": dial tcp 127.0.0.1:6789: connect: connection refused`), ClassTransient},
+		{fmt.Errorf("get balance: %w", context.DeadlineExceeded), ClassTransient},
+		{&PPOSError{Code: 301111, Msg: "The delegation amount is too small"}, ClassPPOS},
+		{&ClassError{Class: ClassNonce, Err: errors.New("replaced")}, ClassNonce},
+		{errors.New("invalid sender"), ClassUnknown},
+		{errors.New("intrinsic gas too low"), ClassInvalid},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.err); got != tt.want {
+			t.Errorf("Classify(%q) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestResultOK(t *testing.T) {
+	addr := &Addr{ArpStr: "lat1"}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "success", want: true},
+		{name: "skipped", err: skipf("nothing to send"), want: true},
+		{name: "ppos", err: &PPOSError{Code: 301111, Msg: "The delegation amount is too small"}, want: true},
+		{name: "insufficient", err: errors.New("insufficient funds for gas * price + value"), want: true},
+		{name: "transient", err: errors.New("i/o timeout"), want: false},
+		{name: "unknown", err: errors.New("get nonce error"), want: false},
+	}
+	for _, tt := range tests {
+		receipt := &Receipt{addr: addr, err: tt.err}
+		_, receipt.skipped = tt.err.(*skipError)
+		r := &Result{Total: 1, Reported: 1, Receipts: []*Receipt{receipt}}
+		if got := r.OK(); got != tt.want {
+			t.Errorf("%s: OK() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	// an address that never reported is retried
+	if r := (&Result{Total: 1}); r.OK() {
+		t.Errorf("unreported: OK() = true")
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := &RetryPolicy{Attempts: 5, Backoff: 5 * time.Second, MaxBackoff: 15 * time.Second}
+	want := []time.Duration{5 * time.Second, 10 * time.Second, 15 * time.Second, 15 * time.Second}
+	for i, d := range want {
+		if got := p.Delay(i + 1); got != d {
+			t.Errorf("Delay(%d) = %s, want %s", i+1, got, d)
+		}
+	}
+	if got := p.Budget(); got != 45*time.Second {
+		t.Errorf("Budget() = %s, want 45s", got)
+	}
+}
+
+func TestSendTxTransient(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := NewLocalSigner(key, big.NewInt(100))
+	addr, err := NewAddr(crypto.PubkeyToAddress(key.PublicKey), signer, "lat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := signer.SignTx(context.Background(), tp.NewTransaction(1, addr.Address, big.NewInt(0), 21000, big.NewInt(1e9), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &testNodeAPI{sendErr: errors.New("i/o timeout")}
+	server := rpc.NewServer()
+	if err = server.RegisterName("platon", api); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	s := &Service{Config: &conf.Config{}, client: client.NewClient(rpc.DialInProc(server))}
+
+	// the node never got it, retried
+	if err = s.sendTx(context.Background(), addr, tx); Classify(err) != ClassTransient {
+		t.Errorf("unknown tx: got %v, class %s", err, Classify(err))
+	}
+	// the node accepted it before the timeout, sent
+	api.hash, api.tx = tx.Hash(), map[string]interface{}{"hash": tx.Hash()}
+	if err = s.sendTx(context.Background(), addr, tx); err != nil {
+		t.Errorf("accepted tx: got %v", err)
+	}
+}
@@ -6,8 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/big"
-	"sync/atomic"
-	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
@@ -20,119 +18,36 @@ import (
 )
 
 const (
-	rewardCode     = int64(5000)
-	rewardGasLimit = uint64(35040)
+	rewardCode = int64(5000)
 )
 
 // Reward ...
 type Reward struct {
-	SvcImpl
-
-	ctx   context.Context
-	addrs []*Addr
-
-	send    chan *Addr
-	receipt chan *Receipt
-
-	receipts int32
-	total    int32
-
-	exit chan struct{}
-}
-
-func (r *Reward) Start() {
-	go r.report()
-	go r.run()
-
-	for _, addr := range r.addrs {
-		r.send <- addr
-		time.Sleep(100 * time.Millisecond)
-	}
+	*worker
 }
 
-func (r *Reward) report() {
-	timeout := time.After(time.Duration(r.total) * 2 * time.Second)
-	t := time.NewTicker(time.Millisecond * 500)
-	for {
-		select {
-		case <-t.C:
-			klog.Infof("[Reward report] current total: %d, receipts: %d", r.total, r.receipts)
-			if r.total == r.receipts {
-				close(r.exit)
-			}
-		case <-timeout:
-			klog.Infof("[Reward report] timeout, total: %d", r.total)
-			close(r.exit)
-		case <-r.ctx.Done():
-			close(r.exit)
-		case <-r.exit:
-			return
-		}
-	}
-}
-
-func (r *Reward) run() {
-	for {
-		select {
-		case addr := <-r.send:
-			klog.Infof("[Reward run] receive address: %s, begin send transaction", addr.ArpStr)
-			go r.sendTransaction(addr)
-		case receipt := <-r.receipt:
-			err := receipt.err
-			if err != nil {
-				klog.Errorf("[Reward run] current address: %s, get reward err: %s", receipt.addr.ArpStr, err)
-			} else {
-				if r.IsAsync() {
-					klog.Infof("[Reward run] current address: %s", receipt.addr.ArpStr)
-				} else {
-					klog.Infof("[Reward run] current address: %s, get reward hash tx: %s", receipt.addr.ArpStr, receipt.tx.Hash().Hex())
-				}
-			}
-			go r.add()
-		case <-r.exit:
-			return
-		}
-	}
-}
-
-func (r *Reward) add() {
-	atomic.AddInt32(&r.receipts, 1)
-}
-
-func (r *Reward) sendTransaction(addr *Addr) {
-	var (
-		tx  *tp.Transaction
-		err error
-	)
-	defer func() {
-		<-time.After(time.Second)
-		r.receipt <- &Receipt{
-			addr: addr,
-			tx:   tx,
-			err:  err,
-		}
-	}()
-
-	reward, err := r.ListRewards(r.ctx, addr)
+func (r *Reward) sendTransaction(addr *Addr) (tx *tp.Transaction, err error) {
+	reward, err := r.reward(addr)
 	if err != nil {
 		err = fmt.Errorf("[Reward sendTransaction] current address: %s, list reward error: %s", addr.ArpStr, err)
 		return
 	}
 	if reward.Cmp(big.NewInt(0).SetInt64(utils.BaseVon)) == -1 {
-		err = fmt.Errorf("[Reward sendTransaction] current address: %s reward less then 1: %s", addr.ArpStr, utils.HumReadBalance(reward))
+		err = skipf("[Reward sendTransaction] current address: %s reward less then 1: %s", addr.ArpStr, utils.HumReadBalance(reward))
 		return
 	}
-	nonce, err := r.GetNonce(r.ctx, addr.ArpStr)
+	nonce, err := r.NextNonce(r.ctx, addr)
 	if err != nil {
 		err = fmt.Errorf("[Reward sendTransaction] current address: %s get nonce err: %s", addr.ArpStr, err)
 		return
 	}
-	tx, err = r.RunReward(r.ctx, addr, nonce)
+	tx, err = r.RunReward(r.ctx, addr, reward, nonce)
 	if err != nil {
-		err = fmt.Errorf("[Reward sendTransaction] current address %s get reward failed %s", addr.ArpStr, err)
+		err = fmt.Errorf("[Reward sendTransaction] current address %s get reward failed %w", addr.ArpStr, err)
 		return
 	}
 	klog.Infof("[Reward sendTransaction] finished send get_reward, current address: %s, nonce: %d", addr.ArpStr, nonce)
+	return
 }
 
 // ListRewards list address rewards
@@ -147,7 +62,12 @@ func (s *Service) ListRewards(ctx context.Context, addr *Addr) (reward *big.Int,
 	if err != nil {
 		return
 	}
+	return decodeRewards(rewardByte)
+}
 
+// decodeRewards sums the rewards of a 5100 query result.
+func decodeRewards(rewardByte []byte) (reward *big.Int, err error) {
+	reward = big.NewInt(0)
 	var can types.RewardResponse
 	err = json.Unmarshal(rewardByte, &can)
 	if err != nil {
@@ -164,31 +84,19 @@ func (s *Service) ListRewards(ctx context.Context, addr *Addr) (reward *big.Int,
 	return
 }
 
-func (s *Service) WithdrawReward(ctx context.Context) {
-	addrs := []*Addr{}
-	for _, address := range s.Addrs {
-		addr, err := NewAddr(address.PrivateKey, s.Arp, address.NodeID)
-		if err != nil {
-			panic(err)
-		}
-		addrs = append(addrs, addr)
-	}
-	reward := &Reward{
-		SvcImpl: s,
-		ctx:     ctx,
-		addrs:   addrs,
-
-		send:    make(chan *Addr, len(addrs)),
-		receipt: make(chan *Receipt, len(addrs)),
-
-		receipts: 0,
-		total:    int32(len(addrs)),
-		exit:     make(chan struct{}),
-	}
-	go reward.Start()
+// WithdrawReward claims the delegate rewards of every address and waits for
+// the receipts.
+func (s *Service) WithdrawReward(ctx context.Context) *Result {
+	reward := &Reward{}
+	reward.worker = newWorker(ctx, s, "Reward", s.newAddrs(), single(reward.sendTransaction))
+	reward.rewards, reward.retry = true, s.RetryPolicy()
+	return reward.Start()
 }
 
-func (s *Service) RunReward(ctx context.Context, addr *Addr, nonce uint64) (tx *tp.Transaction, err error) {
+// RunReward claims the delegate reward of the address, reward is what it
+// claims.
+func (s *Service) RunReward(ctx context.Context, addr *Addr, reward *big.Int, nonce uint64) (tx *tp.Transaction, err error) {
+	defer s.resetNonceOnError(addr, &err)
 	var (
 		gasPrice *big.Int
 	)
@@ -201,28 +109,33 @@ func (s *Service) RunReward(ctx context.Context, addr *Addr, nonce uint64) (tx *
 	if err != nil {
 		return
 	}
-	gasPrice, err = s.client.GasPrice(ctx)
+	gasPrice, err = s.TxGasPrice(ctx)
 	if err != nil {
 		return
 	}
-	if s.IsAsync() {
-		gasPrice = big.NewInt(0)
+
+	gasLimit, err := s.gasLimit(ctx, addr, rewardCode, buf)
+	if err != nil {
+		return
+	}
+	fee, err := s.reserveFee(ctx, addr, gasLimit, gasPrice, reward)
+	if err != nil {
+		return
 	}
+	defer s.releaseFeeOnError(fee, &err)
 
-	tx, err = tp.SignTx(
+	tx, err = addr.SignTx(ctx,
 		tp.NewTransaction(
 			nonce,
 			common.HexToAddress(address),
 			big.NewInt(0),
-			s.RewardGasLimit,
+			gasLimit,
 			gasPrice,
-			buf),
-		s.signer,
-		addr.PrivateKey)
+			buf))
 	if err != nil {
 		return
 	}
-	err = s.client.SendTransaction(context.Background(), tx)
+	err = s.sendTx(ctx, addr, tx)
 	return
 }
 
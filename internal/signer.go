@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Signer signs the transactions of an address.
+type Signer interface {
+	SignTx(ctx context.Context, tx *tp.Transaction) (*tp.Transaction, error)
+}
+
+// LocalSigner signs with a private key held in the process.
+type LocalSigner struct {
+	key    *ecdsa.PrivateKey
+	signer tp.EIP155Signer
+}
+
+func NewLocalSigner(key *ecdsa.PrivateKey, chainID *big.Int) *LocalSigner {
+	return &LocalSigner{key: key, signer: tp.NewEIP155Signer(chainID)}
+}
+
+func (l *LocalSigner) SignTx(ctx context.Context, tx *tp.Transaction) (*tp.Transaction, error) {
+	return tp.SignTx(tx, l.signer, l.key)
+}
+
+// RemoteSigner asks a clef style signer to sign through account_signTransaction,
+// so the key lives in a separate process.
+type RemoteSigner struct {
+	client  *rpc.Client
+	address common.Address
+	chainID *big.Int
+	signer  tp.EIP155Signer
+}
+
+// DialRemoteSigner connects to the signer at rawURL, which signs for address.
+func DialRemoteSigner(ctx context.Context, rawURL string, address common.Address, chainID *big.Int) (*RemoteSigner, error) {
+	c, err := rpc.DialContext(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteSigner{client: c, address: address, chainID: chainID, signer: tp.NewEIP155Signer(chainID)}, nil
+}
+
+// signTxArgs are the transaction arguments of account_signTransaction.
+type signTxArgs struct {
+	From     common.MixedcaseAddress  `json:"from"`
+	To       *common.MixedcaseAddress `json:"to"`
+	Gas      hexutil.Uint64           `json:"gas"`
+	GasPrice hexutil.Big              `json:"gasPrice"`
+	Value    hexutil.Big              `json:"value"`
+	Nonce    hexutil.Uint64           `json:"nonce"`
+	Data     hexutil.Bytes            `json:"data"`
+	ChainID  *hexutil.Big             `json:"chainId,omitempty"`
+}
+
+// signTxResult is the result of account_signTransaction.
+type signTxResult struct {
+	Raw hexutil.Bytes `json:"raw"`
+}
+
+func (r *RemoteSigner) SignTx(ctx context.Context, tx *tp.Transaction) (*tp.Transaction, error) {
+	args := &signTxArgs{
+		From:     common.NewMixedcaseAddress(r.address),
+		Gas:      hexutil.Uint64(tx.Gas()),
+		GasPrice: hexutil.Big(*tx.GasPrice()),
+		Value:    hexutil.Big(*tx.Value()),
+		Nonce:    hexutil.Uint64(tx.Nonce()),
+		Data:     tx.Data(),
+		ChainID:  (*hexutil.Big)(r.chainID),
+	}
+	if tx.To() != nil {
+		to := common.NewMixedcaseAddress(*tx.To())
+		args.To = &to
+	}
+	var res signTxResult
+	if err := r.client.CallContext(ctx, &res, "account_signTransaction", args); err != nil {
+		return nil, fmt.Errorf("remote signer: %s", err)
+	}
+	signed := new(tp.Transaction)
+	if err := rlp.DecodeBytes(res.Raw, signed); err != nil {
+		return nil, fmt.Errorf("remote signer: invalid transaction: %s", err)
+	}
+	// never send what the signer changed or signed with another key
+	from, err := tp.Sender(r.signer, signed)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer: %s", err)
+	}
+	if from != r.address {
+		return nil, fmt.Errorf("remote signer: signed by %s, want %s", from.Hex(), r.address.Hex())
+	}
+	if r.signer.Hash(signed) != r.signer.Hash(tx) {
+		return nil, fmt.Errorf("remote signer: signed transaction differs from the request")
+	}
+	return signed, nil
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// testSignerAPI stands in for the account namespace of clef.
+type testSignerAPI struct {
+	key     *ecdsa.PrivateKey
+	chainID *big.Int
+	tamper  bool
+}
+
+func (api *testSignerAPI) SignTransaction(args signTxArgs) (*signTxResult, error) {
+	to := args.To.Address()
+	gas := uint64(args.Gas)
+	if api.tamper {
+		gas++
+	}
+	tx := tp.NewTransaction(uint64(args.Nonce), to, args.Value.ToInt(), gas, args.GasPrice.ToInt(), args.Data)
+	signed, err := tp.SignTx(tx, tp.NewEIP155Signer(api.chainID), api.key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := rlp.EncodeToBytes(signed)
+	if err != nil {
+		return nil, err
+	}
+	return &signTxResult{Raw: raw}, nil
+}
+
+func TestRemoteSigner(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainID := big.NewInt(100)
+	api := &testSignerAPI{key: key, chainID: chainID}
+	server := rpc.NewServer()
+	if err = server.RegisterName("account", api); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	signer, err := DialRemoteSigner(context.Background(), ts.URL, address, chainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := tp.NewTransaction(7, common.HexToAddress("0x1000000000000000000000000000000000000002"), big.NewInt(0), 50000, big.NewInt(1e9), []byte{0xc1, 0x01})
+	signed, err := signer.SignTx(context.Background(), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := NewLocalSigner(key, chainID).SignTx(context.Background(), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.Hash() != local.Hash() {
+		t.Errorf("got hash %s, want %s", signed.Hash().Hex(), local.Hash().Hex())
+	}
+
+	api.tamper = true
+	if _, err = signer.SignTx(context.Background(), tx); err == nil {
+		t.Error("accepted a transaction changed by the signer")
+	}
+	api.tamper = false
+
+	other, err := DialRemoteSigner(context.Background(), ts.URL, common.HexToAddress("0x01"), chainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.SignTx(context.Background(), tx); err == nil {
+		t.Error("accepted a transaction signed by another key")
+	}
+}
@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/p2p/discv5"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"gitee.com/zonzpoo/platonjob/client"
+	"gitee.com/zonzpoo/platonjob/utils"
+	"gitee.com/zonzpoo/platonjob/utils/types"
+)
+
+// https://devdocs.platon.network/docs/zh-CN/Python_SDK  staking query functions
+const (
+	verifierListCode  = int64(1100)
+	validatorListCode = int64(1101)
+	candidateListCode = int64(1102)
+	relatedListCode   = int64(1103)
+	delegateInfoCode  = int64(1104)
+	candidateInfoCode = int64(1105)
+	lockInfoCode      = int64(1106)
+)
+
+// GetVerifierList returns the verifiers of the current settlement epoch.
+func (s *Service) GetVerifierList(ctx context.Context) (list []*types.Validator, err error) {
+	err = s.query(ctx, verifierListCode, &list)
+	return
+}
+
+// GetValidatorList returns the validators of the current consensus round.
+func (s *Service) GetValidatorList(ctx context.Context) (list []*types.Validator, err error) {
+	err = s.query(ctx, validatorListCode, &list)
+	return
+}
+
+// GetCandidateList returns all the candidates, staked or not elected.
+func (s *Service) GetCandidateList(ctx context.Context) (list []*types.Candidate, err error) {
+	err = s.query(ctx, candidateListCode, &list)
+	return
+}
+
+// GetRelatedListByDelAddr returns the nodes the address has delegated to.
+func (s *Service) GetRelatedListByDelAddr(ctx context.Context, address common.Address) (list []*types.DelegationRelated, err error) {
+	err = s.query(ctx, relatedListCode, &list, address)
+	return
+}
+
+// GetDelegateInfo returns the delegation of the address to the node staked at stakingBlockNum.
+func (s *Service) GetDelegateInfo(ctx context.Context, stakingBlockNum uint64, address common.Address, nodeID discv5.NodeID) (delegation *types.Delegation, err error) {
+	err = s.query(ctx, delegateInfoCode, &delegation, stakingBlockNum, address, nodeID)
+	return
+}
+
+// GetCandidateInfo returns the staking information of the node.
+func (s *Service) GetCandidateInfo(ctx context.Context, nodeID discv5.NodeID) (candidate *types.Candidate, err error) {
+	err = s.query(ctx, candidateInfoCode, &candidate, nodeID)
+	return
+}
+
+// GetDelegationLockInfo returns the locked and redeemable undelegated amounts of the address.
+func (s *Service) GetDelegationLockInfo(ctx context.Context, address common.Address) (info *types.DelegationLockInfo, err error) {
+	err = s.query(ctx, lockInfoCode, &info, address)
+	return
+}
+
+// query calls the built-in contract of fnType and decodes its Ret into ret.
+func (s *Service) query(ctx context.Context, fnType int64, ret interface{}, params ...interface{}) (err error) {
+	address := utils.ContractAddr(fnType)
+	if address == "" {
+		err = fmt.Errorf("invalid contract code: %d", fnType)
+		return
+	}
+	contractAddr, err := utils.ConvertAndEncode(s.Arp, common.HexToAddress(address).Bytes())
+	if err != nil {
+		err = fmt.Errorf("invalid contract address: %s", err)
+		return
+	}
+	buf, err := pposBufData(fnType, params...)
+	if err != nil {
+		return
+	}
+
+	resByte, err := s.client.CallContract(ctx, client.CallMsg{From: contractAddr, To: contractAddr, Data: buf}, nil)
+	if err != nil {
+		return
+	}
+	var res types.Response
+	err = json.Unmarshal(resByte, &res)
+	if err != nil {
+		return
+	}
+	if res.Code != 0 {
+		var msg string
+		if json.Unmarshal(res.Ret, &msg) != nil {
+			msg = string(res.Ret)
+		}
+		err = &PPOSError{FnType: fnType, Code: uint32(res.Code), Msg: msg}
+		return
+	}
+	return json.Unmarshal(res.Ret, ret)
+}
+
+func toBig(b *hexutil.Big) *big.Int {
+	if b == nil {
+		return big.NewInt(0)
+	}
+	return (*big.Int)(b)
+}
+
+// pposBufData rlp encodes fnType and params of a built-in contract call.
+func pposBufData(fnType int64, params ...interface{}) (buf []byte, err error) {
+	fn, err := rlp.EncodeToBytes(uint16(fnType))
+	if err != nil {
+		return
+	}
+	items := make([][]byte, 0, len(params)+1)
+	items = append(items, fn)
+	for _, param := range params {
+		var item []byte
+		item, err = rlp.EncodeToBytes(param)
+		if err != nil {
+			return
+		}
+		items = append(items, item)
+	}
+
+	byteBuf := new(bytes.Buffer)
+	err = rlp.Encode(byteBuf, items)
+	if err != nil {
+		return
+	}
+	buf = byteBuf.Bytes()
+	return
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/p2p/discv5"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"gitee.com/zonzpoo/platonjob/client"
+	"gitee.com/zonzpoo/platonjob/conf"
+)
+
+// callNode answers platon_call with the response of the function called.
+type callNode struct {
+	responses map[int64]string
+}
+
+func (n *callNode) Call(args map[string]interface{}, block string) (hexutil.Bytes, error) {
+	data, err := hexutil.Decode(args["data"].(string))
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Bytes(n.responses[pposFnType(data)]), nil
+}
+
+func TestQuery(t *testing.T) {
+	node := &callNode{responses: map[int64]string{
+		candidateListCode: `{"Code":0,"Ret":[{"NodeId":"0x1f3a8672348ff6b789e416762ad53e69063138b8eb4d8780101658f24b2369f1a8e09499226b467d8bc0c4e03e1dc903df857eeb3c67733d21b6aaee2840e429","Status":32,"Shares":"0x176b344f2a78c00000","DelegateTotal":"0x8ac7230489e80000"}]}`,
+		lockInfoCode:      `{"Code":0,"Ret":{"Locks":[],"Released":"0x4563918244f40000","RestrictingPlan":"0xde0b6b3a7640000"}}`,
+		delegateInfoCode:  `{"Code":301205,"Ret":"Query delegate info failed:Delegate info is not found"}`,
+	}}
+	server := rpc.NewServer()
+	if err := server.RegisterName("platon", node); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	s := &Service{Config: &conf.Config{Arp: "lat"}, client: client.NewClient(rpc.DialInProc(server))}
+	ctx := context.Background()
+
+	candidates, err := s.GetCandidateList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].Status != 32 || toBig(candidates[0].Shares).String() != "432000000000000000000" {
+		t.Errorf("candidates: got %+v", candidates[0])
+	}
+
+	info, err := s.GetDelegationLockInfo(ctx, common.Address{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toBig(info.Released).String() != "5000000000000000000" || toBig(info.RestrictingPlan).String() != "1000000000000000000" {
+		t.Errorf("lock info: got released %s, restricting %s", info.Released, info.RestrictingPlan)
+	}
+
+	_, err = s.GetDelegateInfo(ctx, 0, common.Address{1}, discv5.NodeID{1})
+	var pposErr *PPOSError
+	if !errors.As(err, &pposErr) || pposErr.Code != 301205 || pposErr.FnType != delegateInfoCode {
+		t.Errorf("delegate info: got %v, want ppos error 301205", err)
+	}
+}
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/p2p/discv5"
+
+	"gitee.com/zonzpoo/platonjob/utils"
+	"gitee.com/zonzpoo/platonjob/utils/types"
+)
+
+// status bits of a candidate, any of them excludes it from delegation
+const (
+	statusInvalided     = uint32(1 << 0)
+	statusLowRatio      = uint32(1 << 1)
+	statusNotEnough     = uint32(1 << 2)
+	statusDuplicateSign = uint32(1 << 3)
+	statusLowRatioDel   = uint32(1 << 4)
+	statusWithdrew      = uint32(1 << 5)
+)
+
+var statusNames = []struct {
+	bit  uint32
+	name string
+}{
+	{statusInvalided, "invalided"},
+	{statusLowRatio, "low block ratio"},
+	{statusNotEnough, "staking not enough"},
+	{statusDuplicateSign, "duplicate sign"},
+	{statusLowRatioDel, "low block ratio removed"},
+	{statusWithdrew, "withdrew"},
+}
+
+// Rank is how a strategy rated a candidate.
+type Rank struct {
+	Candidate *types.Candidate
+	Score     float64
+	Excluded  bool
+	Reason    string
+}
+
+// Strategy rates the candidates to delegate to, the returned ranks are sorted
+// best first and the excluded ones last.
+type Strategy interface {
+	Rank(candidates []*types.Candidate) []*Rank
+}
+
+// DefaultStrategy excludes the candidates that are slashed, withdrawing or
+// not elected (any status bit), those running an older program version than
+// the latest one on the chain and those paying less than MinRewardPer. The
+// others score by the reward ratio paid per million LAT/ATP delegated, so a
+// generous node many delegators already crowd ranks below a quieter one.
+type DefaultStrategy struct {
+	MinRewardPer uint16 // in basis points
+}
+
+func (d *DefaultStrategy) Rank(candidates []*types.Candidate) []*Rank {
+	var latest uint32
+	for _, c := range candidates {
+		if v := c.ProgramVersion &^ 0xff; v > latest {
+			latest = v
+		}
+	}
+
+	ranks := make([]*Rank, 0, len(candidates))
+	for _, c := range candidates {
+		r := &Rank{Candidate: c}
+		// a lowered ratio takes effect next epoch, count the lower one
+		rewardPer := c.RewardPer
+		if c.NextRewardPer < rewardPer {
+			rewardPer = c.NextRewardPer
+		}
+		delegated := new(big.Int).Add(hexInt(c.DelegateTotal), hexInt(c.DelegateTotalHes))
+		switch {
+		case c.Status != 0:
+			r.Excluded, r.Reason = true, "status: "+statusString(c.Status)
+		case c.ProgramVersion&^0xff < latest:
+			r.Excluded, r.Reason = true, fmt.Sprintf("version %s behind %s", versionString(c.ProgramVersion), versionString(latest))
+		case rewardPer < d.MinRewardPer:
+			r.Excluded, r.Reason = true, fmt.Sprintf("reward %.2f%% below %.2f%%", float64(rewardPer)/100, float64(d.MinRewardPer)/100)
+		default:
+			millions, _ := new(big.Float).Quo(new(big.Float).SetInt(delegated), big.NewFloat(utils.BaseVon*1e6)).Float64()
+			r.Score = float64(rewardPer) / 100 / (millions + 1)
+			r.Reason = fmt.Sprintf("reward %.2f%%, delegated %s", float64(rewardPer)/100, utils.HumReadBalance(delegated))
+		}
+		ranks = append(ranks, r)
+	}
+	sort.SliceStable(ranks, func(i, j int) bool {
+		if ranks[i].Excluded != ranks[j].Excluded {
+			return !ranks[i].Excluded
+		}
+		return ranks[i].Score > ranks[j].Score
+	})
+	return ranks
+}
+
+func hexInt(b *hexutil.Big) *big.Int {
+	if b == nil {
+		return new(big.Int)
+	}
+	return b.ToInt()
+}
+
+func statusString(status uint32) string {
+	var names []string
+	for _, s := range statusNames {
+		if status&s.bit != 0 {
+			names = append(names, s.name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("%d", status)
+	}
+	return strings.Join(names, ", ")
+}
+
+func versionString(v uint32) string {
+	return fmt.Sprintf("%d.%d.%d", v>>16&0xff, v>>8&0xff, v&0xff)
+}
+
+// SetStrategy replaces the strategy nodes are selected with.
+func (s *Service) SetStrategy(strategy Strategy) {
+	s.strategy = strategy
+}
+
+// RankCandidates rates the current candidates with the strategy.
+func (s *Service) RankCandidates(ctx context.Context) ([]*Rank, error) {
+	candidates, err := s.GetCandidateList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.strategy.Rank(candidates), nil
+}
+
+// SelectNodes returns the best selectCount() nodes of the strategy, with
+// equal weights.
+func (s *Service) SelectNodes(ctx context.Context) ([]*Node, error) {
+	ranks, err := s.RankCandidates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*Node
+	for _, r := range ranks {
+		if r.Excluded || len(nodes) == s.selectCount() {
+			break
+		}
+		id, err := discv5.HexID(r.Candidate.NodeID)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, &Node{ID: id, Weight: 1})
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no candidate to delegate to")
+	}
+	return nodes, nil
+}
+
+func (s *Service) selectCount() int {
+	if s.Config.Strategy.Count <= 0 {
+		return 1
+	}
+	return s.Config.Strategy.Count
+}
+
+// FallbackNodes returns the configured fallback nodes, else the nodes of the
+// strategy. They are delegated to by the addresses without a healthy node.
+func (s *Service) FallbackNodes(ctx context.Context) ([]*Node, error) {
+	if len(s.Config.FallbackNodes) > 0 {
+		return parseNodes("", s.Config.FallbackNodes)
+	}
+	return s.SelectNodes(ctx)
+}
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"gitee.com/zonzpoo/platonjob/utils"
+	"gitee.com/zonzpoo/platonjob/utils/types"
+)
+
+func TestDefaultStrategyRank(t *testing.T) {
+	lat := func(amount float64) *hexutil.Big { return (*hexutil.Big)(utils.ToVon(amount)) }
+	version := uint32(1<<16 | 1<<8)
+	candidates := []*types.Candidate{
+		{NodeID: "crowded", RewardPer: 8000, NextRewardPer: 8000, ProgramVersion: version, DelegateTotal: lat(9e6)},
+		{NodeID: "quiet", RewardPer: 7000, NextRewardPer: 7000, ProgramVersion: version + 1, DelegateTotal: lat(1e6)},
+		{NodeID: "slashed", RewardPer: 9000, NextRewardPer: 9000, ProgramVersion: version, Status: statusDuplicateSign},
+		{NodeID: "old", RewardPer: 9000, NextRewardPer: 9000, ProgramVersion: 1 << 16},
+		{NodeID: "lowering", RewardPer: 9000, NextRewardPer: 1000, ProgramVersion: version},
+	}
+	ranks := (&DefaultStrategy{MinRewardPer: 5000}).Rank(candidates)
+
+	want := []struct {
+		node     string
+		excluded bool
+	}{
+		{"quiet", false},
+		{"crowded", false},
+		{"slashed", true},
+		{"old", true},
+		{"lowering", true},
+	}
+	if len(ranks) != len(want) {
+		t.Fatalf("got %d ranks, want %d", len(ranks), len(want))
+	}
+	for i, w := range want {
+		if ranks[i].Candidate.NodeID != w.node || ranks[i].Excluded != w.excluded {
+			t.Errorf("rank %d: got %s excluded %v (%s), want %s excluded %v", i, ranks[i].Candidate.NodeID, ranks[i].Excluded, ranks[i].Reason, w.node, w.excluded)
+		}
+	}
+}
@@ -2,61 +2,312 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math/big"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	tp "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/p2p/discv5"
 
+	"k8s.io/klog"
+
 	"gitee.com/zonzpoo/platonjob/client"
 	"gitee.com/zonzpoo/platonjob/conf"
+	"gitee.com/zonzpoo/platonjob/store"
 	"gitee.com/zonzpoo/platonjob/utils"
+	"gitee.com/zonzpoo/platonjob/utils/types"
+)
+
+const (
+	defaultConfirmations  = uint64(1)
+	defaultReceiptTimeout = 120 * time.Second
+	receiptInterval       = 2 * time.Second
+	headInterval          = time.Second
 )
 
 type SvcImpl interface {
 	IsAsync() bool
+	Filter(filter ...string)
+	Addresses() []*Addr
+	Store() *store.Store
 
-	CurrentBlockNumber(ctx context.Context) (number int64)
+	CurrentBlockNumber(ctx context.Context) (number int64, err error)
+	Epoch(ctx context.Context) (epoch, remain int64, err error)
+	EpochOf(ctx context.Context, number int64) (epoch, remain int64)
+	EpochBlocks() int64
+	WatchHeads(ctx context.Context) <-chan *client.Header
+	Endpoints() []*client.EndpointStatus
+	EconomicConfig(ctx context.Context) (*types.EconomicConfig, error)
 	GetNonce(ctx context.Context, arpStr string) (uint64, error)
+	NextNonce(ctx context.Context, addr *Addr) (uint64, error)
+	ResetNonce(addr *Addr)
 	GetBalance(ctx context.Context, arpStr string) (*big.Int, error)
+	Prefetch(ctx context.Context, addrs []*Addr, rewards bool) (map[string]*AddrState, error)
+
+	// receipt
+	ReceiptTimeout() time.Duration
+	RetryPolicy() *RetryPolicy
+	GetTransaction(ctx context.Context, hash common.Hash) (*client.Transaction, error)
+	WaitReceipt(ctx context.Context, hash common.Hash) (*client.Receipt, error)
+	WaitMined(ctx context.Context, addr *Addr, hash common.Hash, tx *tp.Transaction, replaced func(*Replacement)) (*client.Receipt, error)
+
+	// staking
+	GetVerifierList(ctx context.Context) ([]*types.Validator, error)
+	GetValidatorList(ctx context.Context) ([]*types.Validator, error)
+	GetCandidateList(ctx context.Context) ([]*types.Candidate, error)
+	GetRelatedListByDelAddr(ctx context.Context, address common.Address) ([]*types.DelegationRelated, error)
+	GetDelegateInfo(ctx context.Context, stakingBlockNum uint64, address common.Address, nodeID discv5.NodeID) (*types.Delegation, error)
+	GetCandidateInfo(ctx context.Context, nodeID discv5.NodeID) (*types.Candidate, error)
 
 	// award
 	ListRewards(ctx context.Context, addr *Addr) (*big.Int, error)
-	RunReward(ctx context.Context, addr *Addr, nonce uint64) (*tp.Transaction, error)
-	WithdrawReward(ctx context.Context)
+	RunReward(ctx context.Context, addr *Addr, reward *big.Int, nonce uint64) (*tp.Transaction, error)
+	WithdrawReward(ctx context.Context) *Result
 
 	// delegate
-	MinVon() *big.Float
+	MinVon() *big.Int
 	GetDelegateValue(ctx context.Context, arpStr string) (*big.Float, error)
-	RunDelegate(ctx context.Context, nodeID discv5.NodeID, amount *big.Int, addr *Addr, nonce uint64) (*tp.Transaction, error)
-	InitDelegate(ctx context.Context)
+	GetRestrictingInfo(ctx context.Context, address common.Address) (*types.RestrictingInfo, error)
+	GetRestrictingValue(ctx context.Context, address common.Address) (*big.Int, error)
+	RunDelegate(ctx context.Context, nodeID discv5.NodeID, typ uint16, amount *big.Int, addr *Addr, nonce uint64) (*tp.Transaction, error)
+	InitDelegate(ctx context.Context) *Result
+
+	// compound
+	CompoundReserveVon() *big.Int
+	CompoundReward(ctx context.Context) *Result
+
+	// strategy
+	SetStrategy(strategy Strategy)
+	RankCandidates(ctx context.Context) ([]*Rank, error)
+	SelectNodes(ctx context.Context) ([]*Node, error)
+	FallbackNodes(ctx context.Context) ([]*Node, error)
+
+	// migrate
+	NodeHealth(ctx context.Context) (NodeHealth, error)
+	MigrateDelegation(ctx context.Context) *Result
+
+	// undelegate
+	DelegatedValue(ctx context.Context, stakingBlockNum uint64, address common.Address, nodeID discv5.NodeID) (*big.Int, error)
+	RunUndelegate(ctx context.Context, stakingBlockNum uint64, nodeID discv5.NodeID, amount *big.Int, addr *Addr, nonce uint64) (*tp.Transaction, error)
+	Undelegate(ctx context.Context, nodes ...conf.Undelegate) *Result
+
+	// redeem
+	GetDelegationLockInfo(ctx context.Context, address common.Address) (*types.DelegationLockInfo, error)
+	RunRedeem(ctx context.Context, addr *Addr, nonce uint64) (*tp.Transaction, error)
+	RedeemDelegation(ctx context.Context) *Result
+
+	// sweep
+	SweepReserveVon() *big.Int
+	TxGasPrice(ctx context.Context) (*big.Int, error)
+	RunTransfer(ctx context.Context, addr *Addr, to common.Address, amount, gasPrice *big.Int, nonce uint64) (*tp.Transaction, error)
+	Sweep(ctx context.Context) *Result
 }
 
 type Service struct {
 	*conf.Config
 
-	client *client.Client
-	signer tp.EIP155Signer
-	async  *bool
+	client    *client.Client
+	async     *bool
+	filter    []string
+	store     *store.Store
+	econ      *economic
+	addrs     []*Addr
+	dst       common.Address // DstAddr, zero without
+	strategy  Strategy
+	nonces    *nonceManager
+	gasPrices *gasPriceCache
+	// percentiles caches the percentile gas price, fees the fees of the epoch
+	percentiles *gasPriceCache
+	fees        *feeBudget
 }
 
 type Receipt struct {
 	addr *Addr
 	tx   *tp.Transaction
+	hash common.Hash
+
+	status      uint64
+	blockNumber uint64
+	gasUsed     uint64
+	code        uint32
+	skipped     bool
+	replaced    []*Replacement
+	attempts    int
 
 	err error
 }
 
 func New(ctx context.Context, ac *conf.Config) (svc SvcImpl, err error) {
-	client, err := client.DialContext(ctx, ac.RawURL)
+	client, err := dialEndpoints(ctx, ac)
+	if err != nil {
+		return
+	}
+	st, err := store.Open(ac.StateFile)
+	if err != nil {
+		return
+	}
+	switch ac.Stuck.Action {
+	case "", conf.StuckReplace, conf.StuckCancel:
+	default:
+		err = fmt.Errorf("invalid stuck action %q", ac.Stuck.Action)
+		return
+	}
+	switch ac.GasPrice.Mode {
+	case "", conf.GasPriceNode, conf.GasPricePercentile:
+	case conf.GasPriceFixed:
+		if ac.GasPrice.Price <= 0 {
+			err = errors.New("fixed gas price needs a price")
+			return
+		}
+	default:
+		err = fmt.Errorf("invalid gas price mode %q", ac.GasPrice.Mode)
+		return
+	}
+	// the delegations migrate withdraws stay locked without redeem
+	if ac.MigrateBlock > 0 && ac.RedeemBlock < 0 {
+		err = errors.New("migrateBlock needs redeem, redeemBlock is negative")
+		return
+	}
+	var dst common.Address
+	if ac.DstAddr != "" {
+		if dst, err = utils.DecodeAddress(ac.DstAddr); err != nil {
+			err = fmt.Errorf("invalid dstAddr %q: %s", ac.DstAddr, err)
+			return
+		}
+	}
+	// keys are loaded once, a keystore passphrase may be prompted for
+	addrs, err := loadAddrs(ctx, ac)
 	if err != nil {
 		return
 	}
-	svc = &Service{Config: ac, client: client, async: ac.Async, signer: tp.NewEIP155Signer(big.NewInt(ac.ChainID))}
+	svc = &Service{Config: ac, client: client, async: ac.Async, store: st, econ: newEconomic(), addrs: addrs, dst: dst, nonces: newNonceManager(), gasPrices: newGasPriceCache(),
+		percentiles: newGasPriceCache(), fees: newFeeBudget(),
+		strategy: &DefaultStrategy{MinRewardPer: uint16(ac.Strategy.MinRewardPer * 100)}}
 	return
 }
 
-func (s *Service) MinVon() *big.Float {
-	return big.NewFloat(0).SetFloat64(s.MinDelegate)
+// dialEndpoints connects to rawURL and the endpoints of the config.
+func dialEndpoints(ctx context.Context, ac *conf.Config) (*client.Client, error) {
+	var endpoints []client.Endpoint
+	if ac.RawURL != "" {
+		endpoints = append(endpoints, client.Endpoint{URL: ac.RawURL})
+	}
+	for _, e := range ac.Endpoints {
+		endpoints = append(endpoints, client.Endpoint{URL: e.URL, Priority: e.Priority})
+	}
+	return client.DialEndpoints(ctx, endpoints, client.Options{
+		MaxLag: ac.MaxBlockLag,
+		Probe:  time.Duration(ac.ProbeInterval) * time.Second,
+	})
+}
+
+// Endpoints returns the health of the nodes the job sends its requests to.
+func (s *Service) Endpoints() []*client.EndpointStatus {
+	return s.client.Endpoints()
+}
+
+// Store returns the store the scheduler progress is kept in.
+func (s *Service) Store() *store.Store {
+	return s.store
+}
+
+// GetTransaction returns the transaction with the given hash.
+func (s *Service) GetTransaction(ctx context.Context, hash common.Hash) (tx *client.Transaction, err error) {
+	tx, _, err = s.client.TransactionByHash(ctx, hash)
+	return
+}
+
+// MinVon returns the minimum delegation in von.
+func (s *Service) MinVon() *big.Int {
+	return utils.ToVon(s.MinDelegate)
+}
+
+// Filter limits every task to the addresses matching filter, see Addr.Match.
+func (s *Service) Filter(filter ...string) {
+	s.filter = filter
+}
+
+// Addresses returns the addresses the tasks run on.
+func (s *Service) Addresses() []*Addr {
+	return s.newAddrs()
+}
+
+// newAddrs returns the addresses of the config that pass the filter.
+func (s *Service) newAddrs() []*Addr {
+	addrs := []*Addr{}
+	for _, addr := range s.addrs {
+		if addr.Match(s.filter) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// loadAddrs builds the addresses of the config, decrypting their keystores,
+// and derives the addresses of the wallets.
+func loadAddrs(ctx context.Context, ac *conf.Config) ([]*Addr, error) {
+	addrs := []*Addr{}
+	for _, address := range ac.Addrs {
+		addr, err := loadAddr(ctx, ac, address)
+		if err != nil {
+			return nil, fmt.Errorf("address %s: %s", address.Name, err)
+		}
+		addr.Name = address.Name
+		addr.DelegateType = address.DelegateType
+		addr.Undelegate = address.Undelegate
+		addrs = append(addrs, addr)
+	}
+	for _, wallet := range ac.Wallets {
+		nodes, err := parseNodes(wallet.NodeID, wallet.Nodes)
+		if err != nil {
+			return nil, fmt.Errorf("wallet %s: %s", wallet.Name, err)
+		}
+		derived, err := DeriveWallet(wallet)
+		if err != nil {
+			return nil, fmt.Errorf("wallet %s: %s", wallet.Name, err)
+		}
+		for _, d := range derived {
+			addr, err := NewKeyAddr(d.Key, big.NewInt(ac.ChainID), ac.Arp, nodes)
+			if err != nil {
+				return nil, fmt.Errorf("wallet %s: %s", wallet.Name, err)
+			}
+			addr.Name = fmt.Sprintf("%s-%d", wallet.Name, d.Index)
+			addr.DelegateType = wallet.DelegateType
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// loadAddr signs through the remote signer of the address when one is
+// configured, else with its private key.
+func loadAddr(ctx context.Context, ac *conf.Config, address conf.Addr) (*Addr, error) {
+	chainID := big.NewInt(ac.ChainID)
+	nodes, err := parseNodes(address.NodeID, address.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	if address.Signer == "" {
+		key, err := loadKey(ac, address)
+		if err != nil {
+			return nil, err
+		}
+		return NewKeyAddr(key, chainID, ac.Arp, nodes)
+	}
+	if address.PrivateKey != "" || address.Keystore != "" {
+		return nil, fmt.Errorf("signer is set with a privateKey or keystore")
+	}
+	account, err := utils.DecodeAddress(address.Address)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := DialRemoteSigner(ctx, address.Signer, account, chainID)
+	if err != nil {
+		return nil, err
+	}
+	return NewAddr(account, signer, ac.Arp, nodes)
 }
 
 func (s *Service) GetNonce(ctx context.Context, arpStr string) (nonce uint64, err error) {
@@ -72,10 +323,14 @@ func (s *Service) GetDelegateValue(ctx context.Context, arpStr string) (value *b
 	if err != nil {
 		return
 	}
+	return delegateValue(balance), nil
+}
+
+// delegateValue is the balance less the 0.1 LAT/ATP kept for gas.
+func delegateValue(balance *big.Int) *big.Float {
 	baseVon := big.NewFloat(0).SetFloat64(utils.BaseVon)
 	balanceVon := big.NewFloat(0).SetInt(balance)
-	value = balanceVon.Sub(balanceVon, baseVon.Mul(baseVon, big.NewFloat(0).SetFloat64(0.1)))
-	return
+	return balanceVon.Sub(balanceVon, baseVon.Mul(baseVon, big.NewFloat(0).SetFloat64(0.1)))
 }
 
 func (s *Service) IsAsync() (async bool) {
@@ -87,7 +342,36 @@ func (s *Service) IsAsync() (async bool) {
 	return *as
 }
 
-func (s *Service) CurrentBlockNumber(ctx context.Context) (number int64) {
+// Epoch returns the settlement epoch of the current block and the blocks left
+// until it ends.
+func (s *Service) Epoch(ctx context.Context) (epoch, remain int64, err error) {
+	number, err := s.CurrentBlockNumber(ctx)
+	if err != nil {
+		return
+	}
+	epoch, remain = s.EpochOf(ctx, number)
+	return
+}
+
+// EpochOf returns the settlement epoch of block number and the blocks left
+// until it ends.
+func (s *Service) EpochOf(ctx context.Context, number int64) (epoch, remain int64) {
+	blocks := s.EpochBlocks()
+	epoch = number/blocks + 1
+
+	s.econ.lock.RLock()
+	loaded := s.econ.epoch
+	s.econ.lock.RUnlock()
+	if loaded < epoch {
+		s.refreshEconomic(ctx, epoch)
+		blocks = s.EpochBlocks()
+		epoch = number/blocks + 1
+	}
+	remain = blocks*epoch - number
+	return
+}
+
+func (s *Service) CurrentBlockNumber(ctx context.Context) (number int64, err error) {
 	bInt, err := s.client.BlockNumberAt(ctx)
 	if err != nil {
 		return
@@ -95,3 +379,54 @@ func (s *Service) CurrentBlockNumber(ctx context.Context) (number int64) {
 	number = bInt.Int64()
 	return
 }
+
+// WatchHeads sends every new chain head until ctx is done, through a newHeads
+// subscription when rawURL is a websocket, else by polling every second.
+func (s *Service) WatchHeads(ctx context.Context) <-chan *client.Header {
+	return s.client.WatchHeads(ctx, headInterval)
+}
+
+// ReceiptTimeout returns how long a worker waits for a transaction to be mined.
+func (s *Service) ReceiptTimeout() time.Duration {
+	if s.Config.ReceiptTimeout <= 0 {
+		return defaultReceiptTimeout
+	}
+	return time.Duration(s.Config.ReceiptTimeout) * time.Second
+}
+
+func (s *Service) confirmations() uint64 {
+	if s.Confirmations == 0 {
+		return defaultConfirmations
+	}
+	return s.Confirmations
+}
+
+// WaitReceipt waits until the transaction is mined and has the configured
+// number of confirmations. The receipt is fetched again on every poll, so a
+// transaction moved by a reorg reports its final block.
+func (s *Service) WaitReceipt(ctx context.Context, hash common.Hash) (receipt *client.Receipt, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.ReceiptTimeout())
+	defer cancel()
+
+	t := time.NewTicker(receiptInterval)
+	defer t.Stop()
+	for {
+		receipt, err = s.client.TransactionReceipt(ctx, hash)
+		if err == nil {
+			var number *big.Int
+			number, err = s.client.BlockNumberAt(ctx)
+			if err == nil && number.Uint64()+1 >= receipt.BlockNumber+s.confirmations() {
+				return
+			}
+		}
+		if err != nil && !errors.Is(err, client.ErrNotFound) {
+			klog.Warningf("[WaitReceipt] tx %s, get receipt error: %s", hash.Hex(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("tx %s not confirmed: %w", hash.Hex(), ctx.Err())
+		case <-t.C:
+		}
+	}
+}
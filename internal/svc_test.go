@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"gitee.com/zonzpoo/platonjob/client"
+	"gitee.com/zonzpoo/platonjob/conf"
+)
+
+// receiptNode serves the receipt of a transaction, every poll of the receipt
+// sees the next of blocks, 0 while it is not mined, and the next of heads.
+type receiptNode struct {
+	heads  []uint64
+	blocks []uint64
+	polls  int
+}
+
+func (n *receiptNode) at(values []uint64) uint64 {
+	i := n.polls - 1
+	if i >= len(values) {
+		i = len(values) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return values[i]
+}
+
+func (n *receiptNode) BlockNumber() hexutil.Uint64 {
+	return hexutil.Uint64(n.at(n.heads))
+}
+
+func (n *receiptNode) GetTransactionReceipt(hash common.Hash) map[string]interface{} {
+	n.polls++
+	block := n.at(n.blocks)
+	if block == 0 {
+		return nil
+	}
+	return map[string]interface{}{"transactionHash": hash, "blockNumber": hexutil.Uint64(block), "status": hexutil.Uint64(1), "gasUsed": hexutil.Uint64(21000)}
+}
+
+func TestWaitReceipt(t *testing.T) {
+	tests := []struct {
+		name          string
+		confirmations uint64
+		timeout       int64
+		heads, blocks []uint64
+		want          uint64 // block of the receipt, 0 when not confirmed
+		polls         int
+	}{
+		{name: "confirmed", confirmations: 3, timeout: 10, heads: []uint64{12}, blocks: []uint64{10}, want: 10, polls: 1},
+		{name: "pending", confirmations: 1, timeout: 1, heads: []uint64{5}, blocks: []uint64{0}},
+		{name: "too few confirmations", confirmations: 3, timeout: 1, heads: []uint64{11}, blocks: []uint64{10}},
+		// mined in 10, gone after a reorg and mined again in 12
+		{name: "reorged", confirmations: 2, timeout: 10, heads: []uint64{10, 11, 13}, blocks: []uint64{10, 0, 12}, want: 12, polls: 3},
+	}
+	for _, tt := range tests {
+		node := &receiptNode{heads: tt.heads, blocks: tt.blocks}
+		server := rpc.NewServer()
+		if err := server.RegisterName("platon", node); err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewServer(server)
+		rc, err := rpc.Dial(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Service{Config: &conf.Config{Confirmations: tt.confirmations, ReceiptTimeout: tt.timeout}, client: client.NewClient(rc)}
+
+		r, err := s.WaitReceipt(context.Background(), common.HexToHash("0x01"))
+		switch {
+		case tt.want == 0 && err == nil:
+			t.Errorf("%s: got receipt in block %d, want none", tt.name, r.BlockNumber)
+		case tt.want != 0 && err != nil:
+			t.Errorf("%s: got %v, want receipt in block %d", tt.name, err, tt.want)
+		case tt.want != 0 && (r.BlockNumber != tt.want || node.polls != tt.polls):
+			t.Errorf("%s: got block %d after %d polls, want %d after %d", tt.name, r.BlockNumber, node.polls, tt.want, tt.polls)
+		}
+		rc.Close()
+		ts.Close()
+		server.Stop()
+	}
+}
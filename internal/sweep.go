@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"k8s.io/klog"
+
+	"gitee.com/zonzpoo/platonjob/utils"
+)
+
+const (
+	transferGasLimit = uint64(21000)
+	// default gas reserve kept on every address, in LAT/ATP
+	defaultSweepReserve = 0.1
+)
+
+// Sweep moves the spendable balance of every address to the collection address.
+type Sweep struct {
+	*worker
+
+	dst common.Address
+}
+
+func (w *Sweep) sendTransaction(addr *Addr) (tx *tp.Transaction, err error) {
+	if addr.Address == w.dst {
+		err = skipf("[Sweep sendTransaction] current address: %s is the collection address", addr.ArpStr)
+		return
+	}
+	balance, err := w.balance(addr)
+	if err != nil {
+		err = fmt.Errorf("[Sweep sendTransaction] current address: %s, get balance error: %s", addr.ArpStr, err)
+		return
+	}
+	gasPrice, err := w.TxGasPrice(w.ctx)
+	if err != nil {
+		err = fmt.Errorf("[Sweep sendTransaction] current address: %s, get gas price error: %s", addr.ArpStr, err)
+		return
+	}
+	amount := big.NewInt(0).Sub(balance, w.SweepReserveVon())
+	amount.Sub(amount, big.NewInt(0).Mul(gasPrice, big.NewInt(0).SetUint64(transferGasLimit)))
+	if amount.Sign() <= 0 {
+		err = skipf("[Sweep sendTransaction] current address: %s, balance: %s", addr.ArpStr, utils.HumReadBalance(balance))
+		return
+	}
+	nonce, err := w.NextNonce(w.ctx, addr)
+	if err != nil {
+		err = fmt.Errorf("[Sweep sendTransaction] current address: %s get nonce err: %s", addr.ArpStr, err)
+		return
+	}
+	tx, err = w.RunTransfer(w.ctx, addr, w.dst, amount, gasPrice, nonce)
+	if err != nil {
+		err = fmt.Errorf("[Sweep sendTransaction] current address %s transfer failed %w", addr.ArpStr, err)
+		return
+	}
+	klog.Infof("[Sweep sendTransaction] finished send transfer, current address: %s, amount: %s, nonce: %d", addr.ArpStr, utils.HumReadBalance(amount), nonce)
+	return
+}
+
+// SweepReserveVon returns the balance kept on every address for gas, in von.
+func (s *Service) SweepReserveVon() *big.Int {
+	if s.SweepReserve <= 0 {
+		return utils.ToVon(defaultSweepReserve)
+	}
+	return utils.ToVon(s.SweepReserve)
+}
+
+// Sweep transfers the balance of every address above the reserve to DstAddr
+// and waits for the receipts.
+func (s *Service) Sweep(ctx context.Context) *Result {
+	sweep := &Sweep{dst: s.dst}
+	sweep.worker = newWorker(ctx, s, "Sweep", s.newAddrs(), single(sweep.sendTransaction))
+	if s.DstAddr == "" {
+		return sweep.fail(fmt.Errorf("[Sweep] no dstAddr configured"))
+	}
+	return sweep.Start()
+}
+
+// RunTransfer sends a plain value transfer of amount to the address to.
+func (s *Service) RunTransfer(ctx context.Context, addr *Addr, to common.Address, amount, gasPrice *big.Int, nonce uint64) (tx *tp.Transaction, err error) {
+	defer s.resetNonceOnError(addr, &err)
+	fee, err := s.reserveFee(ctx, addr, transferGasLimit, gasPrice, amount)
+	if err != nil {
+		return
+	}
+	defer s.releaseFeeOnError(fee, &err)
+	tx, err = addr.SignTx(ctx,
+		tp.NewTransaction(
+			nonce,
+			to,
+			amount,
+			transferGasLimit,
+			gasPrice,
+			nil))
+	if err != nil {
+		return
+	}
+	err = s.sendTx(ctx, addr, tx)
+	return
+}
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p/discv5"
+	"k8s.io/klog"
+
+	"gitee.com/zonzpoo/platonjob/conf"
+	"gitee.com/zonzpoo/platonjob/utils"
+)
+
+const (
+	withdrawDelegateCode = int64(1005)
+)
+
+// Undelegate withdraws the configured delegations of every address.
+type Undelegate struct {
+	*worker
+
+	nodes map[*Addr][]conf.Undelegate
+}
+
+func (u *Undelegate) sendTransactions(addr *Addr) (txs []*tp.Transaction, err error) {
+	related, err := u.GetRelatedListByDelAddr(u.ctx, addr.Address)
+	var pposErr *PPOSError
+	if errors.As(err, &pposErr) {
+		err = skipf("[Undelegate sendTransactions] current address: %s has no delegation: %s", addr.ArpStr, err)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("[Undelegate sendTransactions] current address: %s, get related list error: %s", addr.ArpStr, err)
+		return
+	}
+	for _, node := range u.nodes[addr] {
+		var nodeID discv5.NodeID
+		nodeID, err = discv5.HexID(node.NodeID)
+		if err != nil {
+			err = fmt.Errorf("[Undelegate sendTransactions] current address: %s, invalid node id %s: %s", addr.ArpStr, node.NodeID, err)
+			return
+		}
+		var (
+			stakingBlockNum uint64
+			found           bool
+		)
+		for _, r := range related {
+			if id, e := discv5.HexID(r.NodeID); e == nil && id == nodeID {
+				stakingBlockNum, found = r.StakingBlockNum, true
+				break
+			}
+		}
+		if !found {
+			klog.Infof("[Undelegate sendTransactions] current address: %s has no delegation to node %s", addr.ArpStr, nodeID.TerminalString())
+			continue
+		}
+
+		var amount *big.Int
+		amount, err = u.DelegatedValue(u.ctx, stakingBlockNum, addr.Address, nodeID)
+		if err != nil {
+			err = fmt.Errorf("[Undelegate sendTransactions] current address: %s, get delegate info error: %s", addr.ArpStr, err)
+			return
+		}
+		if amount.Sign() == 0 {
+			continue
+		}
+		if node.Amount > 0 {
+			if want := utils.ToVon(node.Amount); want.Cmp(amount) == -1 {
+				amount = want
+			}
+		}
+
+		var nonce uint64
+		if nonce, err = u.NextNonce(u.ctx, addr); err != nil {
+			err = fmt.Errorf("[Undelegate sendTransactions] current address: %s, get nonce error: %s", addr.ArpStr, err)
+			return
+		}
+		var tx *tp.Transaction
+		tx, err = u.RunUndelegate(u.ctx, stakingBlockNum, nodeID, amount, addr, nonce)
+		if err != nil {
+			err = fmt.Errorf("[Undelegate sendTransactions] current address %s run undelegate failed %w", addr.ArpStr, err)
+			return
+		}
+		klog.Infof("[Undelegate sendTransactions] finished send undelegate, current address: %s, node: %s, amount: %s, nonce: %d",
+			addr.ArpStr, nodeID.TerminalString(), utils.HumReadBalance(amount), nonce)
+		txs = append(txs, tx)
+	}
+	return
+}
+
+// DelegatedValue returns everything the address has delegated to the node,
+// both hesitating and effective, from free and restricting balance.
+func (s *Service) DelegatedValue(ctx context.Context, stakingBlockNum uint64, address common.Address, nodeID discv5.NodeID) (value *big.Int, err error) {
+	delegation, err := s.GetDelegateInfo(ctx, stakingBlockNum, address, nodeID)
+	if err != nil {
+		return
+	}
+	value = big.NewInt(0)
+	value.Add(value, toBig(delegation.Released))
+	value.Add(value, toBig(delegation.ReleasedHes))
+	value.Add(value, toBig(delegation.RestrictingPlan))
+	value.Add(value, toBig(delegation.RestrictingPlanHes))
+	return
+}
+
+// Undelegate withdraws the delegations configured per address and waits for
+// the receipts. When nodes are given they replace the configuration of every
+// address.
+func (s *Service) Undelegate(ctx context.Context, nodes ...conf.Undelegate) *Result {
+	addrs := []*Addr{}
+	entries := make(map[*Addr][]conf.Undelegate)
+	for _, addr := range s.newAddrs() {
+		undelegate := addr.Undelegate
+		if len(nodes) > 0 {
+			undelegate = nodes
+		}
+		if len(undelegate) == 0 {
+			continue
+		}
+		addrs = append(addrs, addr)
+		entries[addr] = undelegate
+	}
+	undelegate := &Undelegate{nodes: entries}
+	undelegate.worker = newWorker(ctx, s, "Undelegate", addrs, undelegate.sendTransactions)
+	return undelegate.Start()
+}
+
+func (s *Service) RunUndelegate(ctx context.Context, stakingBlockNum uint64, nodeID discv5.NodeID, amount *big.Int, addr *Addr, nonce uint64) (tx *tp.Transaction, err error) {
+	defer s.resetNonceOnError(addr, &err)
+	var (
+		gasPrice *big.Int
+	)
+	address := utils.ContractAddr(withdrawDelegateCode)
+	if address == "" {
+		err = fmt.Errorf("invalid contract code: %d", withdrawDelegateCode)
+		return
+	}
+
+	buf, err := pposBufData(withdrawDelegateCode, stakingBlockNum, nodeID, amount)
+	if err != nil {
+		return
+	}
+	gasPrice, err = s.TxGasPrice(ctx)
+	if err != nil {
+		return
+	}
+
+	gasLimit, err := s.gasLimit(ctx, addr, withdrawDelegateCode, buf)
+	if err != nil {
+		return
+	}
+	fee, err := s.reserveFee(ctx, addr, gasLimit, gasPrice, amount)
+	if err != nil {
+		return
+	}
+	defer s.releaseFeeOnError(fee, &err)
+
+	tx, err = addr.SignTx(ctx,
+		tp.NewTransaction(
+			nonce,
+			common.HexToAddress(address),
+			big.NewInt(0),
+			gasLimit,
+			gasPrice,
+			buf))
+	if err != nil {
+		return
+	}
+	err = s.sendTx(ctx, addr, tx)
+	return
+}
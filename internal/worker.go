@@ -0,0 +1,694 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	tp "github.com/ethereum/go-ethereum/core/types"
+	"k8s.io/klog"
+
+	"gitee.com/zonzpoo/platonjob/client"
+	"gitee.com/zonzpoo/platonjob/store"
+)
+
+// worker fans a task out over the addresses, waits until every transaction it
+// sent is mined and collects the outcome of each address into a Result.
+type worker struct {
+	SvcImpl
+
+	name     string
+	ctx      context.Context
+	epoch    int64
+	epochErr error // the epoch could not be read, nothing is sent
+	addrs    []*Addr
+	// rewards prefetches the delegate rewards with the state of the addresses
+	rewards bool
+	state   map[string]*AddrState
+	// retry tries an address failing to send again, see RetryPolicy
+	retry *RetryPolicy
+
+	sendTransactions func(addr *Addr) ([]*tp.Transaction, error)
+	// resumed sends what is left after the transactions of an earlier run
+	// were confirmed on resume, nil when nothing is
+	resumed func(addr *Addr, receipts []*Receipt) ([]*tp.Transaction, error)
+
+	send    chan *Addr
+	receipt chan []*Receipt
+
+	receipts int32
+	total    int32
+
+	lock   *sync.Mutex
+	result *Result
+	// replacements of the stuck transactions by the hash that was mined
+	replaced map[common.Hash][]*Replacement
+	// receipts of the transactions a task already waited for while sending
+	waited map[common.Hash]*Receipt
+
+	exit chan struct{}
+	once *sync.Once
+}
+
+func newWorker(ctx context.Context, svc SvcImpl, name string, addrs []*Addr, send func(addr *Addr) ([]*tp.Transaction, error)) *worker {
+	epoch, _, err := svc.Epoch(ctx)
+	w := &worker{
+		SvcImpl:  svc,
+		name:     name,
+		ctx:      ctx,
+		epoch:    epoch,
+		epochErr: err,
+		addrs:    addrs,
+
+		sendTransactions: send,
+
+		send:    make(chan *Addr, len(addrs)),
+		receipt: make(chan []*Receipt, len(addrs)),
+
+		receipts: 0,
+		total:    int32(len(addrs)),
+
+		lock:     &sync.Mutex{},
+		result:   &Result{Name: name, Total: len(addrs)},
+		replaced: make(map[common.Hash][]*Replacement),
+		waited:   make(map[common.Hash]*Receipt),
+
+		exit: make(chan struct{}),
+		once: &sync.Once{},
+	}
+	// every transaction sent for the worker is recorded before it is broadcast
+	w.ctx = context.WithValue(ctx, recorderKey{}, txRecorder(w))
+	return w
+}
+
+// Start sends the task for every address and blocks until all receipts are
+// collected or the worker times out.
+func (w *worker) Start() *Result {
+	if w.epochErr != nil {
+		// the records of the run could not be kept by epoch
+		return w.fail(fmt.Errorf("[%s Start] get epoch error: %s", w.name, w.epochErr))
+	}
+	if err := w.Store().Lock(); err != nil {
+		// another process sends for the addresses, a command must not run
+		// alongside the daemon on the same state file
+		return w.fail(fmt.Errorf("[%s Start] lock state error: %s, stop the daemon or use another stateFile", w.name, err))
+	}
+	w.prefetch()
+	go w.report()
+	go w.run()
+
+loop:
+	for _, addr := range w.addrs {
+		select {
+		case w.send <- addr:
+		case <-w.exit:
+			break loop
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	<-w.exit
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.result
+}
+
+// fail fails every address with err without sending.
+func (w *worker) fail(err error) *Result {
+	for _, addr := range w.addrs {
+		w.result.Receipts = append(w.result.Receipts, &Receipt{addr: addr, err: err})
+	}
+	w.result.Reported = len(w.addrs)
+	return w.result
+}
+
+// prefetch reads the state of every address in batches before sending, the
+// addresses it misses read their state on their own.
+func (w *worker) prefetch() {
+	state, err := w.Prefetch(w.ctx, w.addrs, w.rewards)
+	if err != nil {
+		klog.Warningf("[%s prefetch] read state error: %s", w.name, err)
+		return
+	}
+	w.state = state
+}
+
+// prefetched returns the prefetched state of the address, nil when it reads
+// its state on its own.
+func (w *worker) prefetched(addr *Addr) *AddrState {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.state[addr.ArpStr]
+}
+
+// forget drops the prefetched state of the address, it is stale once the
+// address tried to send.
+func (w *worker) forget(addr *Addr) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.state, addr.ArpStr)
+}
+
+// balance returns the prefetched balance of the address, else reads it.
+func (w *worker) balance(addr *Addr) (*big.Int, error) {
+	if st := w.prefetched(addr); st != nil {
+		return st.Balance, nil
+	}
+	return w.GetBalance(w.ctx, addr.ArpStr)
+}
+
+// reward returns the prefetched delegate reward of the address, else reads
+// it.
+func (w *worker) reward(addr *Addr) (*big.Int, error) {
+	if st := w.prefetched(addr); st != nil && st.Reward != nil {
+		return st.Reward, nil
+	}
+	return w.ListRewards(w.ctx, addr)
+}
+
+func (w *worker) close() {
+	w.once.Do(func() { close(w.exit) })
+}
+
+func (w *worker) report() {
+	wait := time.Duration(w.total)*2*time.Second + w.ReceiptTimeout()
+	if w.retry != nil {
+		wait += w.retry.Budget()
+	}
+	timeout := time.After(wait)
+	t := time.NewTicker(time.Millisecond * 500)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			receipts := atomic.LoadInt32(&w.receipts)
+			klog.Infof("[%s report] current total: %d, receipts: %d", w.name, w.total, receipts)
+			if w.total == receipts {
+				w.close()
+				return
+			}
+		case <-timeout:
+			klog.Infof("[%s report] timeout, total: %d", w.name, w.total)
+			w.close()
+			return
+		case <-w.ctx.Done():
+			w.close()
+			return
+		case <-w.exit:
+			return
+		}
+	}
+}
+
+func (w *worker) run() {
+	for {
+		select {
+		case addr := <-w.send:
+			klog.Infof("[%s run] receive address: %s, begin send transaction", w.name, addr.ArpStr)
+			go w.process(addr)
+		case receipts := <-w.receipt:
+			for _, receipt := range receipts {
+				w.log(receipt)
+			}
+			w.lock.Lock()
+			w.result.Reported++
+			w.result.Receipts = append(w.result.Receipts, receipts...)
+			w.lock.Unlock()
+			atomic.AddInt32(&w.receipts, 1)
+		case <-w.exit:
+			return
+		}
+	}
+}
+
+func (w *worker) log(receipt *Receipt) {
+	var pposErr *PPOSError
+	switch {
+	case errors.As(receipt.err, &pposErr):
+		klog.Errorf("[%s run] current address: %s, hash tx: %s, block: %d, ppos error: %s",
+			w.name, receipt.addr.ArpStr, receipt.hash.Hex(), receipt.blockNumber, pposErr)
+	case receipt.skipped:
+		klog.Infof("[%s run] current address: %s, skipped: %s", w.name, receipt.addr.ArpStr, receipt.err)
+	case receipt.err != nil:
+		klog.Errorf("[%s run] current address: %s, err: %s", w.name, receipt.addr.ArpStr, receipt.err)
+	default:
+		klog.Infof("[%s run] current address: %s, hash tx: %s, block: %d, gas used: %d",
+			w.name, receipt.addr.ArpStr, receipt.hash.Hex(), receipt.blockNumber, receipt.gasUsed)
+	}
+}
+
+// process sends the transactions of one address and waits for their receipts.
+// An error after some transactions were sent is reported as an extra receipt.
+func (w *worker) process(addr *Addr) {
+	var (
+		receipts []*Receipt
+		done     bool
+	)
+	defer func() {
+		if !done {
+			w.record(receipts)
+		}
+		w.receipt <- receipts
+	}()
+
+	var (
+		txs      []*tp.Transaction
+		attempts int
+		err      error
+	)
+	receipts, done = w.resume(addr)
+	switch {
+	case done:
+		return
+	case len(receipts) > 0:
+		if w.resumed == nil {
+			return
+		}
+		attempts = 1
+		txs, err = w.resumed(addr, receipts)
+	default:
+		txs, attempts, err = w.sendRetry(addr)
+	}
+	for _, tx := range txs {
+		receipts = append(receipts, w.receiptOf(addr, tx))
+	}
+	if err != nil {
+		var skip *skipError
+		receipts = append(receipts, &Receipt{addr: addr, err: err, skipped: errors.As(err, &skip), attempts: attempts})
+	}
+	if len(receipts) == 0 {
+		receipts = append(receipts, &Receipt{addr: addr, err: skipf("[%s process] current address: %s, nothing to send", w.name, addr.ArpStr), skipped: true})
+	}
+}
+
+// sendRetry sends the transactions of the address. With a retry policy, an
+// attempt that sent nothing and failed for a retryable error is tried again
+// after a backoff, while the epoch lasts. attempts counts the tries.
+func (w *worker) sendRetry(addr *Addr) (txs []*tp.Transaction, attempts int, err error) {
+	for attempts = 1; ; attempts++ {
+		txs, err = w.sendTransactions(addr)
+		if err == nil || len(txs) > 0 || w.retry == nil || attempts >= w.retry.Attempts {
+			return
+		}
+		class := Classify(err)
+		if !class.Retryable() {
+			return
+		}
+		if epoch, _, eerr := w.Epoch(w.ctx); eerr == nil && epoch != w.epoch {
+			klog.Warningf("[%s sendRetry] current address: %s, epoch %d ended, no retry after %s error: %s", w.name, addr.ArpStr, w.epoch, class, err)
+			return
+		}
+		delay := w.retry.Delay(attempts)
+		klog.Warningf("[%s sendRetry] current address: %s, %s error, retry %d/%d in %s: %s", w.name, addr.ArpStr, class, attempts+1, w.retry.Attempts, delay, err)
+		// the retry reads the balance and the reward again
+		w.forget(addr)
+		select {
+		case <-time.After(delay):
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// resume picks up what an earlier run did for the address in this epoch. An
+// address whose transactions all succeeded or failed for good is skipped, and
+// transactions sent but never seen mined are waited for instead of sent
+// again, the replaced ones are left out. A transaction recorded but unknown
+// to the node was never broadcast. Anything else runs as usual.
+func (w *worker) resume(addr *Addr) (receipts []*Receipt, done bool) {
+	var sent, success, final, finalHashes int
+	records := w.Store().Records(w.name, w.epoch, addr.ArpStr)
+	for _, r := range records {
+		switch {
+		case r.Status == store.StatusFailed && r.Final:
+			final++
+			if r.Hash != "" {
+				finalHashes++
+			}
+		case r.Hash == "":
+		case r.Status == store.StatusSent:
+			sent++
+		case r.Status == store.StatusSuccess:
+			success++
+		}
+	}
+
+	if sent > 0 {
+		for _, r := range records {
+			if r.Hash == "" || r.Status != store.StatusSent {
+				continue
+			}
+			hash := common.HexToHash(r.Hash)
+			var tx *tp.Transaction
+			found, err := w.GetTransaction(w.ctx, hash)
+			switch {
+			case errors.Is(err, client.ErrNotFound):
+				// recorded, but the earlier run stopped before broadcasting it
+				klog.Warningf("[%s resume] current address: %s, tx %s recorded by an earlier run is unknown to the node", w.name, addr.ArpStr, r.Hash)
+				w.put(&store.Record{Address: addr.ArpStr, Hash: r.Hash, Status: store.StatusUnsent, Error: "unknown to the node on resume"})
+				sent--
+				continue
+			case err == nil:
+				tx, _ = pendingTx(found)
+			}
+			klog.Infof("[%s resume] current address: %s, wait tx %s sent by an earlier run", w.name, addr.ArpStr, r.Hash)
+			receipts = append(receipts, w.confirm(addr, hash, tx))
+		}
+		if sent == 0 {
+			klog.Infof("[%s resume] current address: %s, nothing was broadcast, run as usual", w.name, addr.ArpStr)
+		}
+		return
+	}
+	switch {
+	case success+finalHashes != countHashes(records):
+	case final > 0:
+		receipts = append(receipts, &Receipt{addr: addr, err: skipf("[%s resume] current address: %s failed for good in epoch %d, retried in the next", w.name, addr.ArpStr, w.epoch), skipped: true})
+		done = true
+	case success > 0:
+		receipts = append(receipts, &Receipt{addr: addr, err: skipf("[%s resume] current address: %s already done in epoch %d", w.name, addr.ArpStr, w.epoch), skipped: true})
+		done = true
+	}
+	return
+}
+
+func countHashes(records []*store.Record) (n int) {
+	for _, r := range records {
+		if r.Hash != "" && r.Status != store.StatusReplaced && r.Status != store.StatusUnsent {
+			n++
+		}
+	}
+	return
+}
+
+// record saves the outcome of the receipts in the store.
+func (w *worker) record(receipts []*Receipt) {
+	for _, receipt := range receipts {
+		r := &store.Record{Address: receipt.addr.ArpStr, Status: store.StatusSuccess}
+		if receipt.hash != (common.Hash{}) {
+			r.Hash = receipt.hash.Hex()
+		}
+		switch {
+		case receipt.skipped:
+			r.Status = store.StatusSkipped
+		case receipt.err != nil:
+			r.Status, r.Final = store.StatusFailed, receipt.final()
+		}
+		if receipt.err != nil {
+			r.Error = receipt.err.Error()
+		}
+		w.put(r)
+	}
+}
+
+// sending records the transaction as sent before it is broadcast.
+func (w *worker) sending(addr *Addr, tx *tp.Transaction) {
+	w.put(&store.Record{Address: addr.ArpStr, Hash: tx.Hash().Hex(), Status: store.StatusSent})
+}
+
+// unsent records that the node refused the transaction, it is not waited for
+// by a later run.
+func (w *worker) unsent(addr *Addr, tx *tp.Transaction, err error) {
+	w.put(&store.Record{Address: addr.ArpStr, Hash: tx.Hash().Hex(), Status: store.StatusUnsent, Error: err.Error()})
+}
+
+func (w *worker) put(r *store.Record) {
+	r.Task, r.Epoch = w.name, w.epoch
+	if err := w.Store().Put(r); err != nil {
+		klog.Errorf("[%s put] current address: %s, save state error: %s", w.name, r.Address, err)
+	}
+}
+
+// receiptOf returns the receipt of the transaction, the one the task already
+// waited for, else it waits for it.
+func (w *worker) receiptOf(addr *Addr, tx *tp.Transaction) *Receipt {
+	w.lock.Lock()
+	receipt, ok := w.waited[tx.Hash()]
+	w.lock.Unlock()
+	if ok {
+		return receipt
+	}
+	return w.confirm(addr, tx.Hash(), tx)
+}
+
+// keepWaited keeps the receipt a task waited for while sending, it is
+// reported with the transaction instead of waiting for it again.
+func (w *worker) keepWaited(tx *tp.Transaction, receipt *Receipt) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.waited[tx.Hash()] = receipt
+}
+
+// confirm waits for the receipt of the transaction and decodes its outcome,
+// tx is what was sent, nil when unknown, the PPOS function type is read from
+// its input and it is replaced when stuck.
+func (w *worker) confirm(addr *Addr, hash common.Hash, tx *tp.Transaction) *Receipt {
+	if tx == nil {
+		r, err := w.WaitReceipt(w.ctx, hash)
+		return w.outcome(addr, hash, nil, r, nil, err)
+	}
+	r, replaced, err := w.wait(addr, hash, tx)
+	return w.outcome(addr, hash, tx, r, replaced, err)
+}
+
+// outcome decodes the receipt r of the transaction hash, or the error waiting
+// for it, see confirm.
+func (w *worker) outcome(addr *Addr, hash common.Hash, tx *tp.Transaction, r *client.Receipt, replaced []*Replacement, err error) (receipt *Receipt) {
+	receipt = &Receipt{addr: addr, tx: tx, hash: hash}
+	var cancelled bool
+	if tx != nil {
+		if r != nil {
+			receipt.tx, cancelled = minedTx(tx, replaced, r.TxHash)
+			receipt.hash = r.TxHash
+		}
+		receipt.replaced = w.replacements(receipt.hash)
+	}
+	if err != nil {
+		receipt.err = fmt.Errorf("[%s confirm] current address: %s, wait receipt error: %s", w.name, addr.ArpStr, err)
+		return
+	}
+	receipt.status, receipt.blockNumber, receipt.gasUsed = r.Status, r.BlockNumber, r.GasUsed
+	if cancelled {
+		receipt.err = fmt.Errorf("[%s confirm] current address: %s, stuck tx %s cancelled by %s", w.name, addr.ArpStr, hash.Hex(), r.TxHash.Hex())
+		return
+	}
+	if r.Status != tp.ReceiptStatusSuccessful {
+		receipt.err = &ClassError{Class: ClassInvalid, Err: fmt.Errorf("[%s confirm] current address: %s, tx %s failed in block %d", w.name, addr.ArpStr, r.TxHash.Hex(), r.BlockNumber)}
+		return
+	}
+	var data []byte
+	if receipt.tx != nil {
+		data = receipt.tx.Data()
+	}
+	err = DecodePPOSResult(data, r.Logs)
+	var pposErr *PPOSError
+	if errors.As(err, &pposErr) {
+		receipt.code, receipt.err = pposErr.Code, err
+		return
+	}
+	if err != nil {
+		receipt.err = fmt.Errorf("[%s confirm] current address: %s, decode receipt log error: %s", w.name, addr.ArpStr, err)
+	}
+	return
+}
+
+// wait waits for tx sent with the hash to be mined, replacing it when stuck.
+// Every replacement is recorded in the store, the replaced transaction is no
+// longer waited for by a later run, and kept for the receipt of the one that
+// was mined.
+func (w *worker) wait(addr *Addr, hash common.Hash, tx *tp.Transaction) (r *client.Receipt, replaced []*Replacement, err error) {
+	r, err = w.WaitMined(w.ctx, addr, hash, tx, func(rep *Replacement) {
+		replaced = append(replaced, rep)
+		w.put(&store.Record{Address: addr.ArpStr, Hash: rep.Old.Hex(), Status: store.StatusReplaced, Error: "replaced by " + rep.New.Hex()})
+	})
+	if r != nil && len(replaced) > 0 {
+		w.lock.Lock()
+		w.replaced[r.TxHash] = append(w.replaced[r.TxHash], replaced...)
+		w.lock.Unlock()
+	}
+	return
+}
+
+// replacements returns the replacements that ended with the transaction hash.
+func (w *worker) replacements(hash common.Hash) []*Replacement {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.replaced[hash]
+}
+
+// single adapts a task that sends at most one transaction per address.
+func single(send func(addr *Addr) (*tp.Transaction, error)) func(addr *Addr) ([]*tp.Transaction, error) {
+	return func(addr *Addr) (txs []*tp.Transaction, err error) {
+		tx, err := send(addr)
+		if tx != nil {
+			txs = append(txs, tx)
+		}
+		return
+	}
+}
+
+// skipError marks an address that had nothing to do in this run. It is
+// reported, but does not fail the run.
+type skipError struct {
+	msg string
+}
+
+func (e *skipError) Error() string {
+	return e.msg
+}
+
+func skipf(format string, a ...interface{}) error {
+	return &skipError{msg: fmt.Sprintf(format, a...)}
+}
+
+// ReceiptInfo is the printable form of a Receipt.
+type ReceiptInfo struct {
+	Name        string `json:"name,omitempty"`
+	Address     string `json:"address"`
+	Hash        string `json:"hash,omitempty"`
+	BlockNumber uint64 `json:"blockNumber,omitempty"`
+	GasUsed     uint64 `json:"gasUsed,omitempty"`
+	Code        uint32 `json:"code,omitempty"`
+	Skipped     bool   `json:"skipped,omitempty"`
+	// Replaced are the stuck transactions Hash was sent in place of
+	Replaced []string `json:"replaced,omitempty"`
+	Attempts int      `json:"attempts,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Class    string   `json:"class,omitempty"`
+}
+
+// Info returns the printable form of the receipt.
+func (r *Receipt) Info() *ReceiptInfo {
+	info := &ReceiptInfo{
+		Name:        r.addr.Name,
+		Address:     r.addr.ArpStr,
+		BlockNumber: r.blockNumber,
+		GasUsed:     r.gasUsed,
+		Code:        r.code,
+		Skipped:     r.skipped,
+	}
+	if r.hash != (common.Hash{}) {
+		info.Hash = r.hash.Hex()
+	}
+	for _, rep := range r.replaced {
+		info.Replaced = append(info.Replaced, rep.Old.Hex())
+	}
+	if r.attempts > 1 {
+		info.Attempts = r.attempts
+	}
+	if r.err != nil {
+		info.Error = r.err.Error()
+	}
+	if r.err != nil && !r.skipped {
+		info.Class = Classify(r.err).String()
+	}
+	return info
+}
+
+// Result is the outcome of one run of a worker, with a receipt for every
+// transaction sent and for every address that failed or was skipped.
+type Result struct {
+	Name     string
+	Total    int // addresses
+	Reported int // addresses that finished before the worker exited
+	Receipts []*Receipt
+}
+
+// Success returns the number of transactions mined successfully.
+func (r *Result) Success() (n int) {
+	for _, receipt := range r.Receipts {
+		if receipt.err == nil {
+			n++
+		}
+	}
+	return
+}
+
+// Skipped returns the number of receipts that had nothing to do.
+func (r *Result) Skipped() (n int) {
+	for _, receipt := range r.Receipts {
+		if receipt.skipped {
+			n++
+		}
+	}
+	return
+}
+
+// Failed returns the number of failed receipts plus the addresses that never
+// reported back.
+func (r *Result) Failed() (n int) {
+	for _, receipt := range r.Receipts {
+		if receipt.err != nil && !receipt.skipped {
+			n++
+		}
+	}
+	return n + r.Total - r.Reported
+}
+
+// Replaced returns the number of stuck transactions that were replaced.
+func (r *Result) Replaced() (n int) {
+	for _, receipt := range r.Receipts {
+		n += len(receipt.replaced)
+	}
+	return
+}
+
+// OK reports whether every address reported and no receipt failed for an
+// error a later run may not hit, see ErrorClass.Final.
+func (r *Result) OK() bool {
+	if r.Reported < r.Total {
+		return false
+	}
+	for _, receipt := range r.Receipts {
+		if receipt.err != nil && !receipt.skipped && !receipt.final() {
+			return false
+		}
+	}
+	return true
+}
+
+// final reports whether the receipt failed the same way until the epoch ends.
+func (r *Receipt) final() bool {
+	return r.err != nil && !r.skipped && Classify(r.err).Final()
+}
+
+// Errors counts the failed receipts by the class of their error.
+func (r *Result) Errors() map[string]int {
+	errs := make(map[string]int)
+	for _, receipt := range r.Receipts {
+		if receipt.err != nil && !receipt.skipped {
+			errs[Classify(receipt.err).String()]++
+		}
+	}
+	return errs
+}
+
+// PPOSErrors counts the failed receipts by the PPOS error they reported.
+func (r *Result) PPOSErrors() map[string]int {
+	errs := make(map[string]int)
+	for _, receipt := range r.Receipts {
+		var pposErr *PPOSError
+		if errors.As(receipt.err, &pposErr) {
+			errs[pposErr.Msg]++
+		}
+	}
+	return errs
+}
+
+func (r *Result) String() string {
+	s := fmt.Sprintf("%s total: %d, success: %d, skipped: %d, failed: %d", r.Name, r.Total, r.Success(), r.Skipped(), r.Failed())
+	if n := r.Replaced(); n > 0 {
+		s += fmt.Sprintf(", replaced: %d", n)
+	}
+	if errs := r.Errors(); len(errs) > 0 {
+		s += fmt.Sprintf(", errors: %v", errs)
+	}
+	if errs := r.PPOSErrors(); len(errs) > 0 {
+		s += fmt.Sprintf(", ppos errors: %v", errs)
+	}
+	return s
+}
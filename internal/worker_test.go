@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	tp "github.com/ethereum/go-ethereum/core/types"
+
+	"gitee.com/zonzpoo/platonjob/client"
+	"gitee.com/zonzpoo/platonjob/utils"
+)
+
+func TestOutcome(t *testing.T) {
+	buf, err := rewardBufData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := tp.NewTransaction(1, common.HexToAddress(utils.ContractAddr(rewardCode)), big.NewInt(0), 30000, big.NewInt(1e9), buf)
+	cancel := common.HexToHash("0x02")
+	w := &worker{name: "Reward", lock: &sync.Mutex{}, replaced: map[common.Hash][]*Replacement{}}
+	addr := &Addr{ArpStr: "lat1"}
+
+	tests := []struct {
+		name     string
+		r        *client.Receipt
+		replaced []*Replacement
+		err      error
+		code     uint32
+		class    ErrorClass
+		final    bool
+		msg      string // part of the error, "" for none
+	}{
+		{name: "success", r: &client.Receipt{TxHash: tx.Hash(), BlockNumber: 10, Status: 1}},
+		{name: "failed", r: &client.Receipt{TxHash: tx.Hash(), BlockNumber: 10}, class: ClassInvalid, final: true, msg: "failed in block 10"},
+		{name: "ppos", r: &client.Receipt{TxHash: tx.Hash(), BlockNumber: 10, Status: 1, Logs: []*client.Log{pposLog(t, "301111")}},
+			code: 301111, class: ClassPPOS, final: true, msg: "301111"},
+		{name: "not confirmed", err: errors.New("tx not confirmed: context deadline exceeded"), class: ClassTransient, msg: "wait receipt error"},
+		{name: "cancelled", r: &client.Receipt{TxHash: cancel, BlockNumber: 10, Status: 1},
+			replaced: []*Replacement{{Old: tx.Hash(), New: cancel, Cancel: true}}, class: ClassUnknown, msg: "cancelled"},
+	}
+	for _, tt := range tests {
+		receipt := w.outcome(addr, tx.Hash(), tx, tt.r, tt.replaced, tt.err)
+		if tt.msg == "" {
+			if receipt.err != nil {
+				t.Errorf("%s: got %v, want no error", tt.name, receipt.err)
+			}
+			continue
+		}
+		if receipt.err == nil || !strings.Contains(receipt.err.Error(), tt.msg) {
+			t.Errorf("%s: got %v, want an error with %q", tt.name, receipt.err, tt.msg)
+			continue
+		}
+		if receipt.code != tt.code || Classify(receipt.err) != tt.class || receipt.final() != tt.final {
+			t.Errorf("%s: got code %d, class %s, final %v, want %d, %s, %v", tt.name, receipt.code, Classify(receipt.err), receipt.final(), tt.code, tt.class, tt.final)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
 	"gopkg.in/yaml.v2"
@@ -16,9 +17,14 @@ import (
 )
 
 var (
-	confPath string
-	cmd      string
-	ac       *conf.Config
+	confPath     string
+	cmd          string
+	addrFilter   string
+	outputFormat string
+	nodeID       string
+	amount       float64
+	keyFile      string
+	ac           *conf.Config
 )
 
 func init() {
@@ -26,7 +32,14 @@ func init() {
 
 	// flag init.
 	flag.StringVar(&confPath, "config", "config/config.yaml", "c config file path")
-	flag.StringVar(&cmd, "cmd", "none", "exec command")
+	flag.StringVar(&cmd, "cmd", "none", "exec command once and exit: "+commandNames())
+	flag.StringVar(&addrFilter, "addr", "", "comma separated names or addresses the command runs on, default all")
+	flag.StringVar(&outputFormat, "output", "table", "command output format: table or json")
+	flag.StringVar(&nodeID, "node", "", "node id for undelegate, overrides the config of every address")
+	flag.Float64Var(&amount, "amount", 0, "amount for undelegate, 0 withdraws the whole delegation")
+	flag.StringVar(&keyFile, "key", "", "file with the hex private key for import-key, prompted for when empty")
+	// klog flags, -v=2 logs every head
+	klog.InitFlags(nil)
 }
 
 func loadConf(path string) error {
@@ -48,13 +61,26 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	// keep the scheduler state next to the config by default
+	if ac.StateFile == "" {
+		ac.StateFile = filepath.Join(filepath.Dir(confPath), "state.json")
+	}
+	if ac.KeystoreDir == "" {
+		ac.KeystoreDir = filepath.Join(filepath.Dir(confPath), "keystore")
+	}
 
-	klog.InitFlags(nil)
+	if cmd != "none" {
+		if err := runCmd(context.Background(), cmd); err != nil {
+			klog.Errorf("run command %s: %s", cmd, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	c := sched.NewController(context.Background(), ac)
 
 	go func() {
-		term := make(chan os.Signal)
+		term := make(chan os.Signal, 1)
 		signal.Notify(term, os.Interrupt, syscall.SIGTERM)
 		select {
 		case <-term:
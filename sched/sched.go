@@ -8,10 +8,14 @@ import (
 
 	"k8s.io/klog"
 
+	"gitee.com/zonzpoo/platonjob/client"
 	"gitee.com/zonzpoo/platonjob/conf"
 	"gitee.com/zonzpoo/platonjob/internal"
 )
 
+// a task whose run was not confirmed is retried after retryInterval
+const retryInterval = time.Minute
+
 // Controller is schedule controller
 type Controller struct {
 	ctx    context.Context
@@ -21,14 +25,18 @@ type Controller struct {
 
 	svc internal.SvcImpl
 
-	rewardBlock   int64
-	delegateBlock int64
-
-	rewardCycle   int64
-	delegateCycle int64
+	tasks []*task
+}
 
-	canReward   bool
-	canDelegate bool
+// task runs once per settlement cycle, as soon as the remaining blocks of the
+// cycle drop to block.
+type task struct {
+	name    string
+	block   int64
+	cycle   int64
+	running int32
+	retry   time.Time // no run before, set when a run was not confirmed
+	run     func(ctx context.Context) *internal.Result
 }
 
 func NewController(parent context.Context, ac *conf.Config) *Controller {
@@ -44,118 +52,133 @@ func NewController(parent context.Context, ac *conf.Config) *Controller {
 		panic(err)
 	}
 
-	c.rewardBlock, c.delegateBlock = ac.RewardBlock, ac.DelegateBlock
-	c.rewardCycle, c.delegateCycle = c.currentCycle(), c.currentCycle()
-	c.canReward, c.canDelegate = false, false
-
+	rewardBlock, delegateBlock, redeemBlock := ac.RewardBlock, ac.DelegateBlock, ac.RedeemBlock
 	// default setting
-	if c.rewardBlock == 0 {
-		c.rewardBlock = 8000
+	if rewardBlock == 0 {
+		rewardBlock = 8000
 	}
-	if c.delegateBlock == 0 {
-		c.delegateBlock = 3000
+	if delegateBlock == 0 {
+		delegateBlock = 3000
+	}
+	if redeemBlock == 0 {
+		redeemBlock = 6000
 	}
 
-	return c
-}
-
-// WithdrawReward ...
-func (c *Controller) WithdrawReward() {
-	t := time.NewTicker(time.Minute)
-	for {
-		select {
-		case <-c.ctx.Done():
-			klog.Info("[WithdrawReward] Received stop signal, exited")
-			return
-		case <-t.C:
-			go c.getReward()
+	cycle, _, err := c.svc.Epoch(c.ctx)
+	if err != nil {
+		panic(err)
+	}
+	c.tasks = []*task{
+		{name: "Reward", block: rewardBlock, cycle: cycle, run: c.svc.WithdrawReward},
+		{name: "Delegate", block: delegateBlock, cycle: cycle, run: c.svc.InitDelegate},
+	}
+	// compounding claims and delegates in one chain at the delegate block,
+	// after redeem freed the unlocked delegations
+	if ac.Compound {
+		c.tasks = []*task{{name: "Compound", block: delegateBlock, cycle: cycle, run: c.svc.CompoundReward}}
+	}
+	// redeem runs unless disabled with a negative block
+	if redeemBlock > 0 {
+		c.tasks = append(c.tasks, &task{name: "Redeem", block: redeemBlock, cycle: cycle, run: c.svc.RedeemDelegation})
+	}
+	// sweep only runs with a collection address, after the rewards are claimed
+	if ac.DstAddr != "" {
+		sweepBlock := ac.SweepBlock
+		if sweepBlock == 0 {
+			sweepBlock = 7000
+		}
+		if sweepBlock > 0 {
+			c.tasks = append(c.tasks, &task{name: "Sweep", block: sweepBlock, cycle: cycle, run: c.svc.Sweep})
 		}
 	}
-}
-
-func (c *Controller) getReward() (err error) {
-	remain := c.remainCycleNumber()
-	canDo := c.safeGetRewardCanDo()
-	klog.Infof("[getReward] current remain cycle blocknumber %d, diff blocknumber %d", remain, c.rewardBlock)
-	if canDo && remain <= c.rewardBlock {
-		c.svc.WithdrawReward(c.ctx)
-		c.safeAddRewardCycle()
+	// migrate only runs when configured, with redeem, see internal.New
+	if ac.MigrateBlock > 0 {
+		c.tasks = append(c.tasks, &task{name: "Migrate", block: ac.MigrateBlock, cycle: cycle, run: c.svc.MigrateDelegation})
+	}
+	// undelegate only runs when configured
+	if ac.UndelegateBlock > 0 {
+		c.tasks = append(c.tasks, &task{
+			name:  "Undelegate",
+			block: ac.UndelegateBlock,
+			cycle: cycle,
+			run: func(ctx context.Context) *internal.Result {
+				return c.svc.Undelegate(ctx)
+			},
+		})
 	}
-	return
-}
 
-func (c *Controller) RunDelegate() (err error) {
-	t := time.NewTicker(time.Minute)
-	for {
-		select {
-		case <-c.ctx.Done():
-			klog.Info("[RunDelegate] Received stop signal, exited")
-			return
-		case <-t.C:
-			go c.initDelegate()
+	// resume the tasks that already completed this cycle before a restart
+	for _, t := range c.tasks {
+		if done := c.svc.Store().Done(t.name); done >= cycle {
+			klog.Infof("[NewController] %s already done in cycle %d, resume from cycle %d", t.name, done, done+1)
+			t.cycle = done + 1
 		}
 	}
-}
 
-func (c *Controller) initDelegate() (err error) {
-	remain := c.remainCycleNumber()
-	canDo := c.safeGetDelegateCanDo()
-	klog.Infof("[initDelegate] current remain cycle blocknumber %d, diff blocknumber %d", remain, c.delegateBlock)
-	if canDo && remain <= c.delegateBlock {
-		c.svc.InitDelegate(c.ctx)
-		c.safeAddDelegateCycle()
-	}
-	return
+	return c
 }
 
-func (c *Controller) currentCycle() int64 {
-	number := c.svc.CurrentBlockNumber(c.ctx)
-	return number/10750 + 1
+// onHead evaluates every task once for the new head.
+func (c *Controller) onHead(head *client.Header) {
+	number := head.Number.Int64()
+	epoch, remain := c.svc.EpochOf(c.ctx, number)
+	// a head every second, only logged verbosely
+	klog.V(2).Infof("[onHead] block %d, cycle %d, remain cycle blocknumber %d", number, epoch, remain)
+	for _, t := range c.tasks {
+		c.evalTask(t, epoch, remain)
+	}
 }
 
-func (c *Controller) remainCycleNumber() int64 {
-	number := c.svc.CurrentBlockNumber(c.ctx)
-	cycle := c.currentCycle()
-	return 10750*cycle - number
+// evalTask starts the task when its cycle is the current one and the remaining
+// blocks reached its block, unless it is still running.
+func (c *Controller) evalTask(t *task, epoch, remain int64) {
+	cycle := atomic.LoadInt64(&t.cycle)
+	if epoch > cycle {
+		klog.Warningf("[evalTask %s] cycle %d missed, move to cycle %d", t.name, cycle, epoch)
+		atomic.CompareAndSwapInt64(&t.cycle, cycle, epoch)
+		cycle = atomic.LoadInt64(&t.cycle)
+	}
+	if epoch != cycle || remain > t.block || time.Now().Before(c.safeGetRetry(t)) {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&t.running, 0, 1) {
+		return
+	}
+	klog.Infof("[evalTask %s] cycle %d, remain cycle blocknumber %d, start", t.name, cycle, remain)
+	go c.doTask(t, cycle)
 }
 
-func (c *Controller) safeSetRewardCanDo(do bool) {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	c.canReward = do
-}
+func (c *Controller) doTask(t *task, cycle int64) {
+	defer atomic.StoreInt32(&t.running, 0)
 
-func (c *Controller) safeGetRewardCanDo() bool {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	return c.canReward
+	res := t.run(c.ctx)
+	klog.Infof("[doTask %s] %s", t.name, res)
+	if !res.OK() {
+		klog.Warningf("[doTask %s] cycle %d not confirmed, retry in %s", t.name, cycle, retryInterval)
+		c.safeSetRetry(t, time.Now().Add(retryInterval))
+		return
+	}
+	if err := c.svc.Store().SetDone(t.name, cycle); err != nil {
+		klog.Errorf("[doTask %s] save state error: %s", t.name, err)
+	}
+	c.safeSetRetry(t, time.Time{})
+	atomic.CompareAndSwapInt64(&t.cycle, cycle, cycle+1)
 }
 
-func (c *Controller) safeSetDelegateCanDo(do bool) {
+func (c *Controller) safeSetRetry(t *task, retry time.Time) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	c.canDelegate = do
+	t.retry = retry
 }
 
-func (c *Controller) safeGetDelegateCanDo() bool {
+func (c *Controller) safeGetRetry(t *task) time.Time {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
-	return c.canDelegate
-}
-
-func (c *Controller) safeAddDelegateCycle() {
-	atomic.AddInt64(&c.delegateCycle, 1)
-}
-
-func (c *Controller) safeAddRewardCycle() {
-	atomic.AddInt64(&c.rewardCycle, 1)
+	return t.retry
 }
 
 // Start ...
 func (c *Controller) Start() {
-	go c.WithdrawReward()
-	go c.RunDelegate()
-
 	c.Loop()
 }
 
@@ -165,28 +188,11 @@ func (c *Controller) Stop() (err error) {
 	return
 }
 
+// Loop evaluates the tasks on every new head, the single source of the block
+// height and cycle.
 func (c *Controller) Loop() {
-	t := time.NewTicker(time.Second * 10)
-	for {
-		select {
-		case <-c.ctx.Done():
-			klog.Info("[Loop] Received stop signal, exited")
-			return
-		case <-t.C:
-			cycle := c.currentCycle()
-			klog.Infof("[Loop] current cycle %d, controller rewardCycle %d, delegateCycle %d", cycle, c.rewardCycle, c.delegateCycle)
-			if cycle == c.rewardCycle {
-				c.safeSetRewardCanDo(true)
-			} else {
-				c.safeSetRewardCanDo(false)
-			}
-
-			if cycle == c.delegateCycle {
-				c.safeSetDelegateCanDo(true)
-			} else {
-				c.safeSetDelegateCanDo(false)
-			}
-
-		}
+	for head := range c.svc.WatchHeads(c.ctx) {
+		c.onHead(head)
 	}
+	klog.Info("[Loop] Received stop signal, exited")
 }
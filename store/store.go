@@ -0,0 +1,226 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// status of a record
+const (
+	StatusSent    = "sent"
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+	// the transaction was stuck and another one was sent with its nonce
+	StatusReplaced = "replaced"
+	// the transaction was signed and recorded, but the node refused it
+	StatusUnsent = "unsent"
+)
+
+// epochs kept in the file, older records are pruned on save
+const keepEpochs = 10
+
+// Record is what a task did for an address in an epoch, one per transaction
+// sent, or one without hash when the address failed or was skipped before
+// sending.
+type Record struct {
+	Task    string    `json:"task"`
+	Epoch   int64     `json:"epoch"`
+	Address string    `json:"address"`
+	Hash    string    `json:"hash,omitempty"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Final   bool      `json:"final,omitempty"` // a failure not retried in the epoch
+	Time    time.Time `json:"time"`
+}
+
+type state struct {
+	// Done is the last epoch each task completed
+	Done    map[string]int64 `json:"done"`
+	Records []*Record        `json:"records"`
+}
+
+// Store keeps the scheduler progress in a json file, so a restart resumes
+// where the job stopped. An empty path keeps it in memory only. Only one
+// process writes the file, see Lock.
+type Store struct {
+	path  string
+	lock  *sync.Mutex
+	state *state
+	// locked is the lock file held while writing, nil until the first write
+	locked *os.File
+}
+
+// Open loads the store at path, a missing file starts an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		lock:  &sync.Mutex{},
+		state: &state{Done: make(map[string]int64)},
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the file into the store, a missing file leaves it empty. The
+// caller holds the lock, or owns the store alone.
+func (s *Store) load() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	st := &state{}
+	if err = json.Unmarshal(data, st); err != nil {
+		return fmt.Errorf("invalid state file %s: %s", s.path, err)
+	}
+	if st.Done == nil {
+		st.Done = make(map[string]int64)
+	}
+	s.state = st
+	return nil
+}
+
+// Lock makes this process the only writer of the file, until it exits, and
+// loads what another process wrote since Open. It fails when another process
+// writes the file, the daemon and a command sending transactions must not
+// run on the same file at once. A store in memory needs no lock.
+func (s *Store) Lock() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.path == "" || s.locked != nil {
+		return nil
+	}
+	if err := s.lockFile(); err != nil {
+		return err
+	}
+	return s.load()
+}
+
+// lockFile takes the lock of the file. The caller holds the lock.
+func (s *Store) lockFile() error {
+	if s.locked != nil {
+		return nil
+	}
+	f, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	if err = lockFile(f); err != nil {
+		f.Close()
+		return fmt.Errorf("state file %s is in use by another process: %s", s.path, err)
+	}
+	s.locked = f
+	return nil
+}
+
+// Put adds the record, or updates the one of the same task, epoch, address and
+// hash, and saves the store.
+func (s *Store) Put(r *Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	for i, old := range s.state.Records {
+		if old.Task == r.Task && old.Epoch == r.Epoch && strings.EqualFold(old.Address, r.Address) && old.Hash == r.Hash {
+			s.state.Records[i] = r
+			return s.save()
+		}
+	}
+	s.state.Records = append(s.state.Records, r)
+	return s.save()
+}
+
+// Records returns the records of the task for the address in the epoch.
+func (s *Store) Records(task string, epoch int64, address string) (records []*Record) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, r := range s.state.Records {
+		if r.Task == task && r.Epoch == epoch && strings.EqualFold(r.Address, address) {
+			copied := *r
+			records = append(records, &copied)
+		}
+	}
+	return
+}
+
+// SetDone marks the task as completed for the epoch.
+func (s *Store) SetDone(task string, epoch int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.state.Done[task] = epoch
+	return s.save()
+}
+
+// Done returns the last epoch the task completed, 0 if never.
+func (s *Store) Done(task string) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.state.Done[task]
+}
+
+// save prunes old records and writes the file through a temporary file, so a
+// crash never leaves it half written. The caller holds the lock.
+func (s *Store) save() error {
+	var latest int64
+	for _, r := range s.state.Records {
+		if r.Epoch > latest {
+			latest = r.Epoch
+		}
+	}
+	records := s.state.Records[:0]
+	for _, r := range s.state.Records {
+		if r.Epoch > latest-keepEpochs {
+			records = append(records, r)
+		}
+	}
+	s.state.Records = records
+	sort.SliceStable(s.state.Records, func(i, j int) bool {
+		return s.state.Records[i].Epoch < s.state.Records[j].Epoch
+	})
+
+	if s.path == "" {
+		return nil
+	}
+	if err := s.lockFile(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Put(&Record{Task: "Reward", Epoch: 3, Address: "lat1abc", Hash: "0x01", Status: StatusSent}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Put(&Record{Task: "Reward", Epoch: 3, Address: "lat1abc", Hash: "0x01", Status: StatusSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SetDone("Reward", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done := s.Done("Reward"); done != 3 {
+		t.Errorf("got done epoch %d, want 3", done)
+	}
+	records := s.Records("Reward", 3, "LAT1ABC")
+	if len(records) != 1 || records[0].Status != StatusSuccess {
+		t.Fatalf("got records %+v, want one success", records)
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	s, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for epoch := int64(1); epoch <= keepEpochs+5; epoch++ {
+		if err = s.Put(&Record{Task: "Delegate", Epoch: epoch, Address: "lat1abc", Status: StatusSkipped}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if records := s.Records("Delegate", 1, "lat1abc"); len(records) != 0 {
+		t.Errorf("epoch 1 not pruned: %+v", records)
+	}
+	if records := s.Records("Delegate", keepEpochs+5, "lat1abc"); len(records) != 1 {
+		t.Errorf("latest epoch pruned")
+	}
+}
+
+func TestStoreLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Put(&Record{Task: "Reward", Epoch: 3, Address: "lat1abc", Hash: "0x01", Status: StatusSent}); err != nil {
+		t.Fatal(err)
+	}
+	if err = other.Lock(); err == nil {
+		t.Errorf("second writer: got no lock error")
+	}
+	if err = other.SetDone("Reward", 3); err == nil {
+		t.Errorf("second writer: got no save error")
+	}
+}
@@ -1,5 +1,12 @@
 package types
 
+import (
+	"encoding/json"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
 // RewardInfo ...
 type RewardInfo struct {
 	NodeID     string `json:"nodeID"`
@@ -12,3 +19,147 @@ type RewardResponse struct {
 	Code int64         `json:"code"`
 	Ret  []*RewardInfo `json:"ret"`
 }
+
+// Response is the {Code, Ret} envelope of the PPOS query functions.
+type Response struct {
+	Code int64           `json:"code"`
+	Ret  json.RawMessage `json:"ret"`
+}
+
+// Validator is an item of GetVerifierList (1100) and GetValidatorList (1101).
+type Validator struct {
+	NodeID              string       `json:"NodeId"`
+	BlsPubKey           string       `json:"BlsPubKey"`
+	StakingAddress      string       `json:"StakingAddress"`
+	BenefitAddress      string       `json:"BenefitAddress"`
+	RewardPer           uint16       `json:"RewardPer"`
+	NextRewardPer       uint16       `json:"NextRewardPer"`
+	StakingTxIndex      uint32       `json:"StakingTxIndex"`
+	ProgramVersion      uint32       `json:"ProgramVersion"`
+	StakingBlockNum     uint64       `json:"StakingBlockNum"`
+	Shares              *hexutil.Big `json:"Shares"`
+	ExternalID          string       `json:"ExternalId"`
+	NodeName            string       `json:"NodeName"`
+	Website             string       `json:"Website"`
+	Details             string       `json:"Details"`
+	ValidatorTerm       uint32       `json:"ValidatorTerm"`
+	DelegateTotal       *hexutil.Big `json:"DelegateTotal"`
+	DelegateRewardTotal *hexutil.Big `json:"DelegateRewardTotal"`
+}
+
+// Candidate is an item of GetCandidateList (1102) and GetCandidateInfo (1105).
+type Candidate struct {
+	NodeID               string       `json:"NodeId"`
+	BlsPubKey            string       `json:"BlsPubKey"`
+	StakingAddress       string       `json:"StakingAddress"`
+	BenefitAddress       string       `json:"BenefitAddress"`
+	RewardPer            uint16       `json:"RewardPer"`
+	NextRewardPer        uint16       `json:"NextRewardPer"`
+	RewardPerChangeEpoch uint32       `json:"RewardPerChangeEpoch"`
+	StakingTxIndex       uint32       `json:"StakingTxIndex"`
+	ProgramVersion       uint32       `json:"ProgramVersion"`
+	Status               uint32       `json:"Status"`
+	StakingEpoch         uint32       `json:"StakingEpoch"`
+	StakingBlockNum      uint64       `json:"StakingBlockNum"`
+	Shares               *hexutil.Big `json:"Shares"`
+	Released             *hexutil.Big `json:"Released"`
+	ReleasedHes          *hexutil.Big `json:"ReleasedHes"`
+	RestrictingPlan      *hexutil.Big `json:"RestrictingPlan"`
+	RestrictingPlanHes   *hexutil.Big `json:"RestrictingPlanHes"`
+	DelegateEpoch        uint32       `json:"DelegateEpoch"`
+	DelegateTotal        *hexutil.Big `json:"DelegateTotal"`
+	DelegateTotalHes     *hexutil.Big `json:"DelegateTotalHes"`
+	DelegateRewardTotal  *hexutil.Big `json:"DelegateRewardTotal"`
+	ExternalID           string       `json:"ExternalId"`
+	NodeName             string       `json:"NodeName"`
+	Website              string       `json:"Website"`
+	Details              string       `json:"Details"`
+}
+
+// DelegationRelated is an item of GetRelatedListByDelAddr (1103).
+type DelegationRelated struct {
+	Addr            string `json:"Addr"`
+	NodeID          string `json:"NodeId"`
+	StakingBlockNum uint64 `json:"StakingBlockNum"`
+}
+
+// Delegation is the result of GetDelegateInfo (1104).
+type Delegation struct {
+	Addr               string       `json:"Addr"`
+	NodeID             string       `json:"NodeId"`
+	StakingBlockNum    uint64       `json:"StakingBlockNum"`
+	DelegateEpoch      uint32       `json:"DelegateEpoch"`
+	Released           *hexutil.Big `json:"Released"`
+	ReleasedHes        *hexutil.Big `json:"ReleasedHes"`
+	RestrictingPlan    *hexutil.Big `json:"RestrictingPlan"`
+	RestrictingPlanHes *hexutil.Big `json:"RestrictingPlanHes"`
+	CumulativeIncome   *hexutil.Big `json:"CumulativeIncome"`
+}
+
+// DelegationLockInfo is the result of GetDelegationLockInfo (1106).
+type DelegationLockInfo struct {
+	Locks           []*DelegationLock `json:"Locks"`
+	Released        *hexutil.Big      `json:"Released"`
+	RestrictingPlan *hexutil.Big      `json:"RestrictingPlan"`
+}
+
+// DelegationLock is an undelegated amount frozen until Epoch.
+type DelegationLock struct {
+	Epoch           uint32       `json:"Epoch"`
+	Released        *hexutil.Big `json:"Released"`
+	RestrictingPlan *hexutil.Big `json:"RestrictingPlan"`
+}
+
+// RestrictingInfo is the result of GetRestrictingInfo (4100).
+type RestrictingInfo struct {
+	Balance *hexutil.Big       `json:"balance"`
+	Debt    *hexutil.Big       `json:"debt"`
+	Plans   []*RestrictingPlan `json:"plans"`
+	Pledge  *hexutil.Big       `json:"Pledge"`
+}
+
+// RestrictingPlan is an amount released at BlockNumber.
+type RestrictingPlan struct {
+	BlockNumber uint64       `json:"blockNumber"`
+	Amount      *hexutil.Big `json:"amount"`
+}
+
+// EconomicConfig is the part of debug_economicConfig the job relies on.
+type EconomicConfig struct {
+	Common  EconomicCommon  `json:"common"`
+	Staking EconomicStaking `json:"staking"`
+}
+
+// EconomicCommon holds the block and epoch timing of the chain.
+type EconomicCommon struct {
+	MaxEpochMinutes     uint64 `json:"maxEpochMinutes"`
+	NodeBlockTimeWindow uint64 `json:"nodeBlockTimeWindow"`
+	PerRoundBlocks      uint64 `json:"perRoundBlocks"`
+	MaxConsensusVals    uint64 `json:"maxConsensusVals"`
+	AdditionalCycleTime uint64 `json:"additionalCycleTime"`
+}
+
+// EconomicStaking holds the staking thresholds and freeze durations.
+type EconomicStaking struct {
+	StakeThreshold           *big.Int `json:"stakeThreshold"`
+	OperatingThreshold       *big.Int `json:"operatingThreshold"`
+	MaxValidators            uint64   `json:"maxValidators"`
+	UnStakeFreezeDuration    uint64   `json:"unStakeFreezeDuration"`
+	UnDelegateFreezeDuration uint64   `json:"unDelegateFreezeDuration"`
+}
+
+// EpochBlocks returns the blocks of a settlement epoch, 0 when the config is incomplete.
+func (c *EconomicConfig) EpochBlocks() uint64 {
+	common := c.Common
+	if common.PerRoundBlocks == 0 || common.MaxConsensusVals == 0 {
+		return 0
+	}
+	// seconds per block
+	interval := common.NodeBlockTimeWindow / common.PerRoundBlocks
+	if interval == 0 {
+		interval = 1
+	}
+	consensusSize := common.PerRoundBlocks * common.MaxConsensusVals
+	rounds := common.MaxEpochMinutes * 60 / (interval * consensusSize)
+	return rounds * consensusSize
+}
@@ -0,0 +1,105 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestEpochBlocks(t *testing.T) {
+	// PlatON mainnet
+	config := &EconomicConfig{Common: EconomicCommon{
+		MaxEpochMinutes:     360,
+		NodeBlockTimeWindow: 20,
+		PerRoundBlocks:      10,
+		MaxConsensusVals:    25,
+	}}
+	if blocks := config.EpochBlocks(); blocks != 10750 {
+		t.Errorf("got %d epoch blocks, want 10750", blocks)
+	}
+
+	if blocks := (&EconomicConfig{}).EpochBlocks(); blocks != 0 {
+		t.Errorf("empty config: got %d epoch blocks, want 0", blocks)
+	}
+}
+
+// responses of the PPOS query functions as the node returns them
+const (
+	verifierListRes  = `{"Code":0,"Ret":[{"NodeId":"0x1f3a8672348ff6b789e416762ad53e69063138b8eb4d8780101658f24b2369f1a8e09499226b467d8bc0c4e03e1dc903df857eeb3c67733d21b6aaee2840e429","BlsPubKey":"0x5a","StakingAddress":"lat1zqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqzlh5ge3","BenefitAddress":"lat1zqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqrdyjj2v","RewardPer":1000,"NextRewardPer":1000,"StakingTxIndex":0,"ProgramVersion":3328,"StakingBlockNum":0,"Shares":"0x176b344f2a78c00000","ExternalId":"","NodeName":"platon.node.1","Website":"www.platon.network","Details":"","ValidatorTerm":0,"DelegateTotal":"0x8ac7230489e80000","DelegateRewardTotal":"0x0"}]}`
+	candidateListRes = `{"Code":0,"Ret":[{"NodeId":"0x1f3a8672348ff6b789e416762ad53e69063138b8eb4d8780101658f24b2369f1a8e09499226b467d8bc0c4e03e1dc903df857eeb3c67733d21b6aaee2840e429","BlsPubKey":"0x5a","StakingAddress":"lat1zqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqzlh5ge3","BenefitAddress":"lat1zqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqrdyjj2v","RewardPer":1000,"NextRewardPer":800,"RewardPerChangeEpoch":12,"StakingTxIndex":0,"ProgramVersion":3328,"Status":32,"StakingEpoch":1,"StakingBlockNum":0,"Shares":"0x176b344f2a78c00000","Released":"0x152d02c7e14af6800000","ReleasedHes":"0x0","RestrictingPlan":"0x0","RestrictingPlanHes":"0x0","DelegateEpoch":120,"DelegateTotal":"0x8ac7230489e80000","DelegateTotalHes":"0x0","DelegateRewardTotal":"0x1bc16d674ec80000","ExternalId":"","NodeName":"platon.node.1","Website":"www.platon.network","Details":""}]}`
+	delegationRes    = `{"Code":0,"Ret":{"Addr":"0xa1548dd61010a742cd66fa2ba7b6a0cb3f1a4d93","NodeId":"0x1f3a8672348ff6b789e416762ad53e69063138b8eb4d8780101658f24b2369f1a8e09499226b467d8bc0c4e03e1dc903df857eeb3c67733d21b6aaee2840e429","StakingBlockNum":0,"DelegateEpoch":118,"Released":"0x8ac7230489e80000","ReleasedHes":"0x0","RestrictingPlan":"0x0","RestrictingPlanHes":"0x4563918244f40000","CumulativeIncome":"0x16345785d8a0000"}}`
+	lockInfoRes      = `{"Code":0,"Ret":{"Locks":[{"Epoch":130,"Released":"0x8ac7230489e80000","RestrictingPlan":"0x0"}],"Released":"0x4563918244f40000","RestrictingPlan":"0xde0b6b3a7640000"}}`
+	restrictingRes   = `{"Code":0,"Ret":{"balance":"0x1bc16d674ec80000","debt":"0x0","plans":[{"blockNumber":107520,"amount":"0xde0b6b3a7640000"}],"Pledge":"0xde0b6b3a7640000"}}`
+	notFoundRes      = `{"Code":301205,"Ret":"Query delegate info failed:Delegate info is not found"}`
+)
+
+func TestDecodeResponse(t *testing.T) {
+	decode := func(data string, ret interface{}) *Response {
+		var res Response
+		if err := json.Unmarshal([]byte(data), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Code == 0 {
+			if err := json.Unmarshal(res.Ret, ret); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return &res
+	}
+	eq := func(name string, got *hexutil.Big, want string) {
+		if got == nil || got.String() != want {
+			t.Errorf("%s: got %v, want %s", name, got, want)
+		}
+	}
+
+	var verifiers []*Validator
+	decode(verifierListRes, &verifiers)
+	if len(verifiers) != 1 || verifiers[0].RewardPer != 1000 || verifiers[0].ProgramVersion != 3328 {
+		t.Fatalf("verifiers: got %+v", verifiers)
+	}
+	eq("verifier shares", verifiers[0].Shares, "0x176b344f2a78c00000")
+	eq("verifier delegate total", verifiers[0].DelegateTotal, "0x8ac7230489e80000")
+
+	var candidates []*Candidate
+	decode(candidateListRes, &candidates)
+	if len(candidates) != 1 || candidates[0].Status != 32 || candidates[0].NextRewardPer != 800 || candidates[0].DelegateEpoch != 120 {
+		t.Fatalf("candidates: got %+v", candidates)
+	}
+	eq("candidate released", candidates[0].Released, "0x152d02c7e14af6800000")
+	eq("candidate delegate reward total", candidates[0].DelegateRewardTotal, "0x1bc16d674ec80000")
+
+	var delegation *Delegation
+	decode(delegationRes, &delegation)
+	if delegation.DelegateEpoch != 118 {
+		t.Errorf("delegation: got epoch %d, want 118", delegation.DelegateEpoch)
+	}
+	eq("delegation released", delegation.Released, "0x8ac7230489e80000")
+	eq("delegation restricting hes", delegation.RestrictingPlanHes, "0x4563918244f40000")
+	eq("delegation income", delegation.CumulativeIncome, "0x16345785d8a0000")
+
+	var lock *DelegationLockInfo
+	decode(lockInfoRes, &lock)
+	if len(lock.Locks) != 1 || lock.Locks[0].Epoch != 130 {
+		t.Fatalf("lock info: got %+v", lock)
+	}
+	eq("lock released", lock.Released, "0x4563918244f40000")
+	eq("lock restricting", lock.RestrictingPlan, "0xde0b6b3a7640000")
+	eq("frozen released", lock.Locks[0].Released, "0x8ac7230489e80000")
+
+	var restricting *RestrictingInfo
+	decode(restrictingRes, &restricting)
+	if len(restricting.Plans) != 1 || restricting.Plans[0].BlockNumber != 107520 {
+		t.Fatalf("restricting: got %+v", restricting)
+	}
+	eq("restricting balance", restricting.Balance, "0x1bc16d674ec80000")
+	eq("restricting pledge", restricting.Pledge, "0xde0b6b3a7640000")
+	eq("restricting plan", restricting.Plans[0].Amount, "0xde0b6b3a7640000")
+
+	// an error code carries the message as Ret
+	res := decode(notFoundRes, nil)
+	var msg string
+	if err := json.Unmarshal(res.Ret, &msg); res.Code != 301205 || err != nil || msg == "" {
+		t.Errorf("error response: got code %d, ret %s", res.Code, res.Ret)
+	}
+}
@@ -2,9 +2,11 @@ package utils
 
 import (
 	"errors"
+	"fmt"
 	"math/big"
 
 	"github.com/btcsuite/btcutil/bech32"
+	"github.com/ethereum/go-ethereum/common"
 )
 
 const (
@@ -52,3 +54,29 @@ func HumReadBalance(balance *big.Int) string {
 	balanceVon := big.NewFloat(0).SetInt(balance)
 	return big.NewFloat(0).Quo(balanceVon, baseVon).String()
 }
+
+// ToVon converts an amount in LAT/ATP to von.
+func ToVon(amount float64) *big.Int {
+	baseVon := big.NewFloat(0).SetFloat64(BaseVon)
+	von, _ := big.NewFloat(0).Mul(big.NewFloat(0).SetFloat64(amount), baseVon).Int(nil)
+	return von
+}
+
+// DecodeAddress decodes a bech32 (lat/atp) or hex address.
+func DecodeAddress(address string) (common.Address, error) {
+	if common.IsHexAddress(address) {
+		return common.HexToAddress(address), nil
+	}
+	_, data, err := bech32.Decode(address)
+	if err != nil {
+		return common.Address{}, err
+	}
+	converted, err := bech32.ConvertBits(data, 5, 8, false)
+	if err != nil {
+		return common.Address{}, errors.New("decoding bech32 failed")
+	}
+	if len(converted) != common.AddressLength {
+		return common.Address{}, fmt.Errorf("invalid address length: %d", len(converted))
+	}
+	return common.BytesToAddress(converted), nil
+}